@@ -1,66 +1,90 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"os"
-	"strconv"
-	"syscall"
-)
+	"strings"
+	"time"
 
-// File name for storing number of last stored job.
-const counter = "job-counter"
+	"github.com/hknutzen/Netspoc-API/internal/queue"
+)
 
 type jsonMap map[string]any
 
+// Are replaced in tests.
+var (
+	timeNow      = time.Now
+	newRequestID = func() string {
+		b := make([]byte, 16)
+		rand.Read(b)
+		return hex.EncodeToString(b)
+	}
+)
+
 // Read job from body, add job to queue, give ID of job as result.
-func addJob(w http.ResponseWriter, body []byte) {
+// Job is stored as given by client, but without password and
+// without configured sensitive fields.
+func (conf *config) addJob(
+	w http.ResponseWriter, r *http.Request, body []byte, auth *identity) {
+
+	var obj jsonObject
+	if err := json.Unmarshal(body, &obj); err != nil {
+		badRequest(w, "Invalid job: "+err.Error())
+		return
+	}
+	if obj.has(queue.MetaKey) {
+		badRequest(w, "Attribute '"+queue.MetaKey+"' is reserved")
+		return
+	}
 	var job jsonMap
 	json.Unmarshal(body, &job)
-	// Delete password from request, must not be stored in queue.
-	delete(job, "pass")
-	// Jobs are stored in directory waiting/ in files 1, 2, 3, ...
-	os.Mkdir("waiting", 0755)
-	fh, err := os.OpenFile(counter, os.O_CREATE|os.O_RDWR, 0644)
-	if err != nil {
-		internalErr(w, err.Error())
+	allowed := methodsAllowed(auth.methods, job)
+	if u := conf.User[auth.user]; u != nil {
+		allowed = allowed && methodsAllowed(u.Methods, job)
 	}
-	// Lock counter for exclusive access.
-	err = syscall.Flock(int(fh.Fd()), syscall.LOCK_EX)
-	if err != nil {
-		internalErr(w, "Can't get lock: "+err.Error())
+	if !allowed {
+		forbidden(w, "Method is not allowed")
+		return
 	}
-	// Read job count; is empty on first run.
-	count := 0
-	fmt.Fscan(fh, &count)
-	// Increment count and write back.
-	count++
-	fh.Seek(0, 0)
-	_, err = fmt.Fprintln(fh, count)
-	if err != nil {
-		internalErr(w, "Writing job-counter: "+err.Error())
-	}
-	fh.Close()
-	// Write job to temp file to prevent reading of partial written
-	// file.
-	os.Mkdir("tmp", 0755)
-	tmpName := fmt.Sprintf("tmp/%d", count)
-	fh, err = os.Create(tmpName)
-	if err != nil {
-		internalErr(w, err.Error())
+	// Delete password from request, must not be stored in queue.
+	// Keys are removed case-insensitively, because password is
+	// taken from any case of "pass" during authentication.
+	obj.remove("pass")
+	for _, k := range conf.SensitiveFields {
+		obj.remove(k)
 	}
-	enc := json.NewEncoder(fh)
-	enc.SetEscapeHTML(false)
-	enc.Encode(job)
-	fh.Close()
-	// Move temp file to queue.
-	jobName := fmt.Sprintf("waiting/%d", count)
-	err = os.Rename(tmpName, jobName)
+	// Store name of authenticated user, needed to check access in
+	// jobStatus. User may be missing in body if token was used.
+	obj.set(queue.MetaKey, queue.Meta{
+		User:      auth.user,
+		Submitted: timeNow().UTC().Format(time.RFC3339),
+		Source:    conf.clientAddr(r).String(),
+		RequestID: requestID(r),
+	})
+	data, _ := obj.MarshalJSON()
+	var b bytes.Buffer
+	json.Compact(&b, data)
+	b.WriteByte('\n')
+	id, err := jobStore.Add(b.Bytes())
 	if err != nil {
 		internalErr(w, err.Error())
+		return
 	}
 	// Give ID of created job as answer.
-	enc = json.NewEncoder(w)
-	enc.Encode(jsonMap{"id": strconv.Itoa(count)})
+	enc := json.NewEncoder(w)
+	enc.Encode(jsonMap{"id": id})
+}
+
+// Take ID of request from header set by proxy or create new one.
+func requestID(r *http.Request) string {
+	id := r.Header.Get("X-Request-ID")
+	if id == "" || len(id) > 128 || strings.IndexFunc(id, func(c rune) bool {
+		return c <= ' ' || c > '~'
+	}) != -1 {
+		return newRequestID()
+	}
+	return id
 }
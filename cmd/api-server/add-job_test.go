@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecoverCounter(t *testing.T) {
+	setupConfig(t, `{`+adminUsers+`}`)
+	os.WriteFile("job-counter", []byte("5\n"), 0644)
+	for _, f := range []string{"waiting/7", "finished/17", "result/12"} {
+		os.Mkdir(filepath.Dir(f), 0755)
+		os.WriteFile(f, []byte("{}"), 0644)
+	}
+	os.Mkdir("tmp", 0755)
+	os.WriteFile("tmp/new-job-123", nil, 0644)
+	store, err := openJobStore(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	data, _ := os.ReadFile("job-counter")
+	eq(t, "17\n", string(data))
+	resp := call("/add-job", "", `{"user": "u1", "pass": "secret"}`)
+	eq(t, `{"id":"18"}`+"\n", resp.Body.String())
+	if entries, _ := os.ReadDir("tmp"); len(entries) != 1 {
+		t.Errorf("Temporary file of job has not been removed")
+	}
+}
+
+func TestStoreVerbatim(t *testing.T) {
+	setupConfig(t, `{"sensitive_fields": ["secret"], `+adminUsers+`}`)
+	req := httptest.NewRequest(http.MethodPost, "/add-job", strings.NewReader(`
+{"method": "add",
+ "params": {"path": "x", "value": 12345678901234567890, "f": 1.50},
+ "pass": "secret", "user": "u1", "secret": "s", "crq": "<CRQ&1>"}`))
+	req.Header.Set("X-Request-ID", "abc-123")
+	resp := httptest.NewRecorder()
+	handleRequest(resp, req)
+	eq(t, `{"id":"1"}`+"\n", resp.Body.String())
+	data, _ := os.ReadFile("waiting/1")
+	eq(t, `{"method":"add",`+
+		`"params":{"path":"x","value":12345678901234567890,"f":1.50},`+
+		`"user":"u1","crq":"<CRQ&1>",`+
+		`"_netspoc_api":{"user":"u1","submitted":"2026-01-02T03:04:05Z",`+
+		`"source":"192.0.2.1","request_id":"abc-123"}}`+"\n",
+		string(data))
+}
+
+func TestULID(t *testing.T) {
+	setupConfig(t, `{"job_store": {"ids": "ulid"}, `+adminUsers+`}`)
+	store, err := openJobStore(current.Load().JobStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := jobStore
+	jobStore = store
+	t.Cleanup(func() { store.Close(); jobStore = old })
+	login := `"user": "u1", "pass": "secret"`
+	resp := call("/add-job", "", `{`+login+`}`)
+	var result struct{ Id string }
+	json.Unmarshal(resp.Body.Bytes(), &result)
+	id := result.Id
+	if len(id) != 26 {
+		t.Fatalf("Expected ULID, got %q", resp.Body.String())
+	}
+	if _, err := os.Stat("waiting/" + id); err != nil {
+		t.Error(err)
+	}
+	os.Mkdir("finished", 0755)
+	os.Mkdir("result", 0755)
+	status := func() string {
+		resp := call("/job-status", "", `{`+login+`, "id": "`+id+`"}`)
+		return resp.Body.String()
+	}
+	eq(t, `{"status":"WAITING"}`+"\n", status())
+	// Job is moved by other process and is seen by index.
+	os.WriteFile("result/"+id, nil, 0644)
+	os.Rename("waiting/"+id, "finished/"+id)
+	for range 100 {
+		if status() != `{"status":"WAITING"}`+"\n" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	eq(t, `{"status":"FINISHED"}`+"\n", status())
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Static API token of some user.
+// Only the hash of the token is stored in config.
+type apiToken struct {
+	// Hex encoded SHA-256 hash of token.
+	Hash string
+	// Token is no longer valid from this day on.
+	Expires     string
+	Description string
+	// Job methods allowed with this token; all methods if empty.
+	Methods []string
+	user    string
+	expires time.Time
+}
+
+// Check API tokens of all users and fill map conf.tokens.
+func (conf *config) setupTokens() error {
+	conf.tokens = make(map[string]*apiToken)
+	for user, uConf := range conf.User {
+		for _, tok := range uConf.Tokens {
+			if b, err := hex.DecodeString(tok.Hash); err != nil ||
+				len(b) != sha256.Size {
+
+				return fmt.Errorf("Invalid 'hash' in token of user '%s'", user)
+			}
+			if tok.Expires == "" {
+				return fmt.Errorf("Missing 'expires' in token of user '%s'", user)
+			}
+			t, err := time.ParseInLocation(time.DateOnly, tok.Expires, time.Local)
+			if err != nil {
+				return fmt.Errorf("Invalid 'expires' in token of user '%s': %v",
+					user, err)
+			}
+			tok.expires = t
+			tok.user = user
+			key := strings.ToLower(tok.Hash)
+			if _, found := conf.tokens[key]; found {
+				return fmt.Errorf("Duplicate token of user '%s'", user)
+			}
+			conf.tokens[key] = tok
+		}
+	}
+	return nil
+}
+
+// Authenticate client by static API token, by session token
+// or by JSON Web Token.
+func (conf *config) tokenAuth(
+	w http.ResponseWriter, r *http.Request, token string, job jsonArgs,
+) *identity {
+	start := time.Now()
+	addr := conf.clientAddr(r).String()
+	if checkLocked(w, "", addr) {
+		return nil
+	}
+	auth := conf.apiTokenAuth(token)
+	if auth == nil {
+		auth = conf.sessionAuth(token)
+	}
+	if auth == nil && conf.JWT != nil && strings.Count(token, ".") == 2 {
+		var err error
+		if auth, err = conf.jwtAuth(token); err != nil {
+			log.Printf("JWT rejected: %v", err)
+		}
+	}
+	if auth == nil {
+		conf.authFailed(w, "", addr, start, unauthorized, "Invalid token")
+		return nil
+	}
+	if job.User != "" && job.User != auth.user {
+		badRequest(w, "Attribute 'user' doesn't match token")
+		return nil
+	}
+	if !conf.checkAccess(w, r, auth.user) {
+		return nil
+	}
+	authSucceeded("", addr)
+	return auth
+}
+
+func (conf *config) apiTokenAuth(token string) *identity {
+	sum := sha256.Sum256([]byte(token))
+	tok := conf.tokens[hex.EncodeToString(sum[:])]
+	if tok == nil || !time.Now().Before(tok.expires) {
+		return nil
+	}
+	return &identity{user: tok.user, methods: tok.Methods}
+}
+
+// Check that all methods of job are in list of allowed methods.
+// Methods of sub jobs of 'multi_job' are checked recursively.
+func methodsAllowed(methods []string, job jsonMap) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	method, _ := job["method"].(string)
+	if !slices.Contains(methods, method) {
+		return false
+	}
+	if method == "multi_job" {
+		params, _ := job["params"].(map[string]any)
+		jobs, _ := params["jobs"].([]any)
+		for _, sub := range jobs {
+			m, _ := sub.(map[string]any)
+			if !methodsAllowed(methods, m) {
+				return false
+			}
+		}
+	}
+	return true
+}
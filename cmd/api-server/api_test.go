@@ -12,19 +12,40 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/hknutzen/testtxt"
 )
 
 type descr struct {
-	Title    string
-	Input    string
-	Output   string
-	URL      string
-	Request  string
-	Response string
-	Status   int
+	Title      string
+	Input      string
+	Output     string
+	URL        string
+	Header     string
+	RemoteAddr string
+	Request    string
+	Response   string
+	Status     int
+	Error      string
+}
+
+func TestMain(m *testing.M) {
+	// Don't slow down tests with failed authentication.
+	failureDelay = 0
+	// Metadata of stored jobs.
+	timeNow = func() time.Time {
+		return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	}
+	newRequestID = func() string { return "42" }
+	os.Exit(m.Run())
+}
+
+// Reset counters of failed authentication from previous test.
+func resetFailures() {
+	userFailures.entries = nil
+	addrFailures.entries = nil
 }
 
 func TestAPI(t *testing.T) {
@@ -55,12 +76,31 @@ func testHandler(t *testing.T, d descr) {
 	os.Setenv("HOME", workDir)
 	testtxt.PrepareInDir(t, workDir, "", d.Input)
 
-	conf = config{}
+	resetFailures()
 	if err := loadConfig(); err != nil {
+		if d.Error != "" {
+			eq(t, strings.TrimSpace(d.Error), err.Error())
+			return
+		}
+		t.Fatal(err)
+	}
+	if d.Error != "" {
+		t.Fatal("Expected error from loadConfig")
+	}
+	if err := initSessions(); err != nil {
 		t.Fatal(err)
 	}
 	req := httptest.NewRequest(
 		http.MethodPost, d.URL, strings.NewReader(d.Request))
+	if d.RemoteAddr != "" {
+		req.RemoteAddr = d.RemoteAddr + ":1234"
+	}
+	// Each line of d.Header is a single header "Name: value".
+	for _, line := range strings.Split(d.Header, "\n") {
+		if k, v, found := strings.Cut(line, ":"); found {
+			req.Header.Add(k, strings.TrimSpace(v))
+		}
+	}
 	resp := httptest.NewRecorder()
 	handleRequest(resp, req)
 	if resp.Code != d.Status {
@@ -76,6 +116,58 @@ func testHandler(t *testing.T, d descr) {
 	}
 }
 
+// Prepare working directory with given config.
+func setupConfig(t *testing.T, data string) {
+	dir, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(dir) })
+	os.Chdir(t.TempDir())
+	os.WriteFile("config", []byte(data), 0600)
+	resetFailures()
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := initSessions(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Hash of password "secret".
+const secretHash = "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS"
+
+// Users "admin" with role admin and "u1", both with password "secret".
+const adminUsers = `"user": {
+  "admin": {"hash": "` + secretHash + `", "roles": ["admin"]},
+  "u1": {"hash": "` + secretHash + `"}
+ }`
+
+// Basic YWRtaW46c2VjcmV0 is admin:secret
+const adminAuth = "Basic YWRtaW46c2VjcmV0"
+
+// Call URL and check status code and body of response.
+// Body isn't checked, if expected is empty.
+func checkResponse(t *testing.T, url, header, body string,
+	code int, expected string) {
+
+	t.Helper()
+	resp := call(url, header, body)
+	if resp.Code != code {
+		t.Errorf("%s: want status %d, got %d: %s",
+			url, code, resp.Code, resp.Body)
+	} else if expected != "" {
+		eq(t, expected, strings.TrimSpace(resp.Body.String()))
+	}
+}
+
+func call(url, header, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if header != "" {
+		req.Header.Set("Authorization", header)
+	}
+	resp := httptest.NewRecorder()
+	handleRequest(resp, req)
+	return resp
+}
+
 func eq(t *testing.T, expected, got string) {
 	if d := cmp.Diff(expected, got); d != "" {
 		t.Error(d)
@@ -145,3 +237,13 @@ func runCmd(t *testing.T, line string) {
 		t.Fatalf("Command failed: %q: %v", line, string(out))
 	}
 }
+
+func TestChallenge(t *testing.T) {
+	setupConfig(t, `{}`)
+	resp := call("/job-status", "", `{"id": "42"}`)
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("Want status 401, got %d", resp.Code)
+	}
+	eq(t, `Basic realm="Netspoc-API"`,
+		resp.Header().Values("WWW-Authenticate")[0])
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Changes of users and of jobs by admin are logged to this file, one JSON object per line.
+const auditFile = "audit-log"
+
+var auditMutex sync.Mutex
+
+type auditEntry struct {
+	Time   string `json:"time"`
+	By     string `json:"by"`
+	Addr   string `json:"addr"`
+	Action string `json:"action"`
+	User   string `json:"user"`
+	Job    string `json:"job,omitempty"`
+}
+
+func (conf *config) audit(r *http.Request, auth *identity, action, user string) {
+	conf.writeAudit(r, auth, auditEntry{Action: action, User: user})
+}
+
+// Log change of job with given ID, that has been added by user.
+func (conf *config) auditJob(
+	r *http.Request, auth *identity, action, id, user string) {
+
+	conf.writeAudit(r, auth, auditEntry{Action: action, User: user, Job: id})
+}
+
+func (conf *config) writeAudit(r *http.Request, auth *identity, e auditEntry) {
+	e.Time = time.Now().UTC().Format(time.RFC3339)
+	e.By = auth.user
+	e.Addr = conf.clientAddr(r).String()
+	line, _ := json.Marshal(e)
+	line = append(line, '\n')
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	fh, err := os.OpenFile(
+		auditFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err == nil {
+		_, err = fh.Write(line)
+		if err2 := fh.Close(); err == nil {
+			err = err2
+		}
+	}
+	if err != nil {
+		log.Printf("Can't write audit log: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// Cache of successfully verified passwords.
+// Key is salted hash of user and password; the salt is created
+// anew, whenever config is loaded.
+// So cache is invalidated, when config is reloaded.
+type authCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	salt    []byte
+	entries map[[sha256.Size]byte]authCacheEntry
+}
+
+type authCacheEntry struct {
+	auth    *identity
+	expires time.Time
+}
+
+// Limit memory used by cache.
+const maxAuthCacheEntries = 10000
+
+var authMetrics = expvar.NewMap("auth_cache")
+
+func init() {
+	authMetrics.Set("hit_rate", expvar.Func(func() any {
+		hits, _ := authMetrics.Get("hits").(*expvar.Int)
+		misses, _ := authMetrics.Get("misses").(*expvar.Int)
+		if hits == nil || misses == nil || hits.Value()+misses.Value() == 0 {
+			return 0.0
+		}
+		return float64(hits.Value()) / float64(hits.Value()+misses.Value())
+	}))
+}
+
+// Returns nil, if ttl is 0. Methods of nil cache do nothing.
+func newAuthCache(ttl time.Duration) *authCache {
+	if ttl == 0 {
+		return nil
+	}
+	salt := make([]byte, 32)
+	rand.Read(salt)
+	return &authCache{
+		ttl:     ttl,
+		salt:    salt,
+		entries: make(map[[sha256.Size]byte]authCacheEntry),
+	}
+}
+
+func (c *authCache) key(user, pass string) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(c.salt)
+	h.Write([]byte(user))
+	h.Write([]byte{0})
+	h.Write([]byte(pass))
+	var k [sha256.Size]byte
+	h.Sum(k[:0])
+	return k
+}
+
+// Check if password of user has been verified recently.
+// Returns identity of user, that has been found then,
+// or nil if not found.
+func (c *authCache) lookup(user, pass string) *identity {
+	if c == nil {
+		return nil
+	}
+	k := c.key(user, pass)
+	c.mutex.Lock()
+	e, found := c.entries[k]
+	c.mutex.Unlock()
+	if found && time.Now().Before(e.expires) {
+		authMetrics.Add("hits", 1)
+		return e.auth
+	}
+	authMetrics.Add("misses", 1)
+	return nil
+}
+
+func (c *authCache) store(user, pass string, auth *identity) {
+	if c == nil {
+		return
+	}
+	k := c.key(user, pass)
+	now := time.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.entries) >= maxAuthCacheEntries {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxAuthCacheEntries {
+			return
+		}
+	}
+	c.entries[k] = authCacheEntry{auth: auth, expires: now.Add(c.ttl)}
+}
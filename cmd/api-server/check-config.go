@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Check config file strictly and print summary of users.
+// Unknown attributes are rejected.
+// Returns false, if some problem has been found.
+func checkConfig(file string, out io.Writer) bool {
+	problems := 0
+	problem := func(err error) {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintln(out, "Error:", line)
+			problems++
+		}
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		problem(err)
+		return false
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	conf := new(config)
+	if err := dec.Decode(conf); err != nil {
+		problem(fmt.Errorf("error while reading %s: %s", file, err))
+		return false
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		problem(fmt.Errorf("Unexpected data after JSON object in %s", file))
+	}
+	if err := conf.setup(); err != nil {
+		problem(err)
+	}
+	defer conf.close()
+	// Files are only read by server, if TLS listener is used.
+	if conf.TLS != nil {
+		if _, _, err := conf.TLS.loadFiles(); err != nil {
+			problem(err)
+		}
+	}
+	var summary []string
+	for _, user := range slices.Sorted(maps.Keys(conf.User)) {
+		methods := conf.authMethods(conf.User[user])
+		if methods == nil {
+			problem(fmt.Errorf(
+				"No authentication method for user '%s'", user))
+			methods = []string{"none"}
+		}
+		summary = append(summary, user+": "+strings.Join(methods, ", "))
+	}
+	for _, line := range summary {
+		fmt.Fprintln(out, line)
+	}
+	if conf.LDAP != nil && conf.LDAP.Groups != nil {
+		fmt.Fprintf(out, "Members of %d LDAP groups are authorized\n", len(conf.LDAP.Groups))
+	}
+	if conf.JWT != nil && conf.JWT.GroupRoles != nil {
+		fmt.Fprintf(out, "Members of %d groups from JWT are authorized\n",
+			len(conf.JWT.GroupRoles))
+	}
+	if problems != 0 {
+		fmt.Fprintf(out, "%d problems found\n", problems)
+		return false
+	}
+	fmt.Fprintln(out, "Config is valid")
+	return true
+}
+
+// Describe how user can be authenticated.
+// Gives nil, if user can't be authenticated at all.
+func (conf *config) authMethods(u *userConfig) []string {
+	var result []string
+	add := func(s string) { result = append(result, s) }
+	switch {
+	case strings.HasPrefix(u.Hash, "$argon2id$"):
+		add("password (argon2id)")
+	case strings.HasPrefix(u.Hash, "$2"):
+		add("password (bcrypt)")
+	case u.Hash != "":
+		add("password")
+	case u.LDAP:
+		add("LDAP")
+	}
+	if n := len(u.Tokens); n != 0 {
+		add(fmt.Sprintf("%d API tokens", n))
+	}
+	if conf.IdentityHeader != "" {
+		add("identity header")
+	}
+	if conf.TLS != nil && conf.TLS.ClientCA != "" {
+		add("client certificate")
+	}
+	if conf.JWT != nil {
+		add("JWT")
+	}
+	if result == nil {
+		return nil
+	}
+	if u.Roles != nil {
+		add("roles " + strings.Join(u.Roles, " "))
+	}
+	if u.Disabled {
+		add("disabled")
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	hash := "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS"
+	argon := "$argon2id$v=19$m=64,t=1,p=1$cUAdMJKnhlWzXw8gpJEbPQ$" +
+		"13uZ48RBr6SQzs246JpFI6yOO3ftrosrBNwfN5Z8yvY"
+	for _, c := range []struct {
+		title, config, output string
+		ok                    bool
+	}{
+		{
+			title: "Valid config",
+			config: `{
+ "ldap": {"uri": "ldap://ldap.example.com",
+          "group_base_dn": "dc=example,dc=com",
+          "groups": {"cn=netspoc,dc=example,dc=com": ["admin"]}},
+ "user": {
+  "u1": {"hash": "` + hash + `", "roles": ["admin"]},
+  "u2": {"hash": "` + argon + `", "disabled": true},
+  "u3": {"ldap": true,
+         "tokens": [{"hash": "` + strings.Repeat("a", 64) + `",
+                     "expires": "2099-01-01"}]}
+ }
+}`,
+			output: `
+u1: password (bcrypt), roles admin
+u2: password (argon2id), disabled
+u3: LDAP, 1 API tokens
+Members of 1 LDAP groups are authorized
+Config is valid
+`,
+			ok: true,
+		},
+		{
+			title:  "Unknown attribute",
+			config: `{"user": {"u1": {"hash": "` + hash + `", "role": ["admin"]}}}`,
+			output: `
+Error: error while reading config: json: unknown field "role"
+`,
+		},
+		{
+			title: "Collect all problems",
+			config: `{
+ "forwarded_hops": -1,
+ "lockout": {"threshold": -1},
+ "user": {
+  "u1": {"hash": "secret"},
+  "u2": {"allowed_networks": ["10.1.1.0/24"]},
+  "u3": {"ldap": true}
+ }
+}`,
+			output: `
+Error: No 'ldap_uri' has been configured
+Error: 'forwarded_hops' must not be negative
+Error: Invalid 'hash' of user 'u1': unknown format of hash
+Error: Values in 'lockout' must not be negative
+Error: No authentication method for user 'u2'
+u1: password
+u2: none
+u3: LDAP
+5 problems found
+`,
+		},
+		{
+			title: "Missing TLS files",
+			config: `{
+ "tls": {"cert": "missing.pem", "key": "missing.key"},
+ "user": {"u1": {"hash": "` + hash + `"}}
+}`,
+			output: `
+Error: Can't load TLS certificate: open missing.pem: no such file or directory
+u1: password (bcrypt)
+1 problems found
+`,
+		},
+	} {
+		t.Run(c.title, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "config")
+			os.WriteFile(file, []byte(c.config), 0600)
+			var out strings.Builder
+			ok := checkConfig(file, &out)
+			eq(t, strings.TrimPrefix(c.output, "\n"),
+				strings.ReplaceAll(out.String(), file, "config"))
+			if ok != c.ok {
+				t.Errorf("Want result %v, got %v", c.ok, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Serialize changes of config file.
+var configFileMutex sync.Mutex
+
+// Change content of config file atomically.
+// Previous content is kept in backup file with suffix ".bak".
+func updateConfigFile(edit func([]byte) ([]byte, error)) error {
+	configFileMutex.Lock()
+	defer configFileMutex.Unlock()
+	data, err := os.ReadFile(confFile)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(confFile)
+	if err != nil {
+		return err
+	}
+	data, err = edit(data)
+	if err != nil {
+		return err
+	}
+	tmp := confFile + ".tmp"
+	os.Remove(tmp)
+	fh, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY,
+		info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = fh.Write(data)
+	if err == nil {
+		err = fh.Sync()
+	}
+	if err2 := fh.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	bak := confFile + ".bak"
+	os.Remove(bak)
+	if err := os.Link(confFile, bak); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, confFile); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(confFile))
+}
+
+// Make changed entries of directory persistent.
+func syncDir(dir string) error {
+	fh, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = fh.Sync()
+	if err2 := fh.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// JSON object, that keeps order of its keys, when written back.
+// Keys are matched case-insensitively like encoding/json does,
+// when decoding into a struct.
+type jsonObject struct {
+	keys   []string
+	values map[string]json.RawMessage
+	// Keys are matched exactly, if object is decoded into a map,
+	// e.g. names of users.
+	exact bool
+}
+
+func (o *jsonObject) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != json.Delim('{') {
+		return errors.New("expected JSON object")
+	}
+	o.keys = nil
+	o.values = make(map[string]json.RawMessage)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		k := t.(string)
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return err
+		}
+		if _, found := o.values[k]; !found {
+			o.keys = append(o.keys, k)
+		}
+		o.values[k] = v
+	}
+	_, err = dec.Token()
+	return err
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(k)
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(o.values[k])
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// Existing keys matching k.
+func (o *jsonObject) matching(k string) []string {
+	if o.exact {
+		if _, found := o.values[k]; found {
+			return []string{k}
+		}
+		return nil
+	}
+	var l []string
+	for _, e := range o.keys {
+		if strings.EqualFold(e, k) {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+func (o *jsonObject) has(k string) bool {
+	return o.matching(k) != nil
+}
+
+// Get value of key as JSON object; missing value gives empty object.
+// If key is found multiple times, last value is used like in
+// encoding/json.
+func (o *jsonObject) object(k string) (*jsonObject, error) {
+	sub := &jsonObject{values: make(map[string]json.RawMessage)}
+	if l := o.matching(k); l != nil {
+		if err := json.Unmarshal(o.values[l[len(l)-1]], sub); err != nil {
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// Set value of key. Value of first matching key is replaced and other
+// matching keys are removed.
+func (o *jsonObject) set(k string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	l := o.matching(k)
+	if l == nil {
+		o.keys = append(o.keys, k)
+	} else {
+		k = l[0]
+		o.removeKeys(l[1:])
+	}
+	o.values[k] = data
+	return nil
+}
+
+func (o *jsonObject) remove(k string) {
+	o.removeKeys(o.matching(k))
+}
+
+func (o *jsonObject) removeKeys(l []string) {
+	for _, k := range l {
+		delete(o.values, k)
+	}
+	o.keys = slices.DeleteFunc(o.keys, func(e string) bool {
+		return slices.Contains(l, e)
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hknutzen/Netspoc-API/internal/queue"
+)
+
+// Handle requests /requeue-job and /fail-job for job in progress.
+// Job is requeued, i.e. moved back to state WAITING, or it is
+// finished with an error message. This is used for stalled jobs,
+// if the backend has died while processing the job.
+func (conf *config) manageJob(
+	w http.ResponseWriter, r *http.Request, req jsonArgs, auth *identity) {
+
+	if !conf.hasRole(auth, adminRole) {
+		forbidden(w, "Role 'admin' is required")
+		return
+	}
+	id := req.Id
+	if id == "" {
+		badRequest(w, "Missing 'id'")
+		return
+	}
+	job, err := jobStore.Get(id)
+	if err == queue.ErrNotFound {
+		badRequest(w, "Unknown job '"+id+"'")
+		return
+	}
+	if err != nil {
+		internalErr(w, err.Error())
+		return
+	}
+	if job.State != queue.InProgress {
+		badRequest(w, "Job '"+id+"' isn't in progress")
+		return
+	}
+	action := r.URL.Path[1:]
+	if action == "requeue-job" {
+		err = jobStore.Move(id, queue.InProgress, queue.Waiting, nil)
+	} else {
+		msg := fmt.Sprintf(
+			"Error: Job has timed out, was in progress since %s\n",
+			job.Changed.UTC().Format(time.RFC3339))
+		err = jobStore.Move(id, queue.InProgress, queue.Finished, []byte(msg))
+	}
+	if err == queue.ErrNotFound {
+		// Has been moved meanwhile.
+		badRequest(w, "Job '"+id+"' isn't in progress")
+		return
+	}
+	if err != nil {
+		internalErr(w, err.Error())
+		return
+	}
+	// Job with invalid JSON is logged without user.
+	info, _ := job.Info()
+	conf.auditJob(r, auth, action, id, info.User)
+	json.NewEncoder(w).Encode(jsonMap{})
+}
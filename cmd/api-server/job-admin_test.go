@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStalledJob(t *testing.T) {
+	setupConfig(t, `{"stall_timeout": "1h", `+adminUsers+`}`)
+	// Job has been in progress for 2 hours.
+	changed := time.Now()
+	old := timeNow
+	timeNow = func() time.Time { return changed.Add(2 * time.Hour) }
+	t.Cleanup(func() { timeNow = old })
+	for _, d := range []string{"waiting", "inprogress", "finished"} {
+		os.Mkdir(d, 0755)
+	}
+	os.WriteFile("inprogress/5", []byte(`{"_netspoc_api": {"user": "u1"}}`), 0644)
+	// Time, when job has been moved to inprogress/.
+	since := func() string {
+		j, _ := jobStore.Get("5")
+		return j.Changed.UTC().Format(time.RFC3339)
+	}
+
+	check := func(url, header, body string, code int, expected string) {
+		t.Helper()
+		checkResponse(t, url, header, body, code, expected)
+	}
+	status := func(expected string) {
+		t.Helper()
+		check("/job-status", "", `{"user": "u1", "pass": "secret", "id": "5"}`,
+			http.StatusOK, expected)
+	}
+	status(`{"message":"Job is in progress since ` + since() +
+		`, for longer than 1h0m0s","status":"STALLED"}`)
+	// Other user doesn't see details of job.
+	check("/job-status", adminAuth, `{"id": "5"}`,
+		http.StatusOK, `{"status":"INPROGRESS"}`)
+
+	check("/requeue-job", "", `{"user": "u1", "pass": "secret", "id": "5"}`,
+		http.StatusForbidden, "Role 'admin' is required")
+	check("/requeue-job", adminAuth, `{}`,
+		http.StatusBadRequest, "Missing 'id'")
+	check("/requeue-job", adminAuth, `{"id": "9"}`,
+		http.StatusBadRequest, "Unknown job '9'")
+	check("/requeue-job", adminAuth, `{"id": "5"}`, http.StatusOK, "{}")
+	status(`{"status":"WAITING"}`)
+	check("/fail-job", adminAuth, `{"id": "5"}`,
+		http.StatusBadRequest, "Job '5' isn't in progress")
+
+	os.Rename("waiting/5", "inprogress/5")
+	msg := "Error: Job has timed out, was in progress since " + since()
+	check("/fail-job", adminAuth, `{"id": "5"}`, http.StatusOK, "{}")
+	status(`{"message":"` + msg + `\n","status":"ERROR"}`)
+
+	data, _ := os.ReadFile(auditFile)
+	var actions []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e auditEntry
+		json.Unmarshal([]byte(line), &e)
+		actions = append(actions, e.By+":"+e.Action+":"+e.User+":"+e.Job)
+	}
+	eq(t, "admin:requeue-job:u1:5 admin:fail-job:u1:5",
+		strings.Join(actions, " "))
+}
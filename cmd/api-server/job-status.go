@@ -2,9 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
-	"os"
 	"strings"
+	"time"
+
+	"github.com/hknutzen/Netspoc-API/internal/queue"
 )
 
 // Show processing status of given job as result.
@@ -17,51 +20,70 @@ import (
 // - UNKNOWN
 // or
 //   - ERROR
+//   - STALLED
 //     with additional attribute "message".
-func jobStatus(w http.ResponseWriter, req jsonArgs) {
-	exists := func(p string) bool {
-		_, err := os.Stat(p)
-		return err == nil
-	}
-	var status string
+func (conf *config) jobStatus(
+	w http.ResponseWriter, req jsonArgs, auth *identity) {
+
 	result := jsonMap{}
-	id := req.Id
-	if exists("waiting/" + id) {
-		status = "WAITING"
-	} else if exists("inprogress/" + id) {
-		status = "INPROGRESS"
-	} else if data, err := os.ReadFile("finished/" + id); err == nil {
-		var job jsonArgs
-		if err := json.Unmarshal(data, &job); err != nil {
+	job, err := jobStore.Get(req.Id)
+	switch {
+	case err == queue.ErrNotFound:
+		result["status"] = "UNKNOWN"
+	case err != nil:
+		internalErr(w, err.Error())
+		return
+	case conf.stalled(job) && ownJob(job, auth):
+		result["status"] = "STALLED"
+		result["message"] = fmt.Sprintf(
+			"Job is in progress since %s, for longer than %s",
+			job.Changed.UTC().Format(time.RFC3339),
+			time.Duration(conf.StallTimeout))
+	case job.State != queue.Finished:
+		result["status"] = job.State
+	default:
+		info, err := job.Info()
+		if err != nil {
 			internalErr(w, "Job has invalid JSON: "+err.Error())
 			return
 		}
-		if req.User != job.User {
-			status = "DENIED"
-		} else {
-			data, err := os.ReadFile("result/" + id)
-			if err != nil {
-				internalErr(w, err.Error())
+		if auth.user != info.User {
+			result["status"] = "DENIED"
+			break
+		}
+		data, err := jobStore.Result(job.ID)
+		if err != nil {
+			internalErr(w, err.Error())
+			return
+		}
+		if len(data) != 0 {
+			msg := string(data)
+			if strings.Contains(msg, "try again") {
+				msg := strings.TrimSuffix(msg, "\n")
+				// Client should add job again on this result.
+				internalErr(w, msg)
 				return
 			}
-			if len(data) != 0 {
-				msg := string(data)
-				if strings.Contains(msg, "try again") {
-					msg := strings.TrimSuffix(msg, "\n")
-					// Client should add job again on this result.
-					internalErr(w, msg)
-					return
-				}
-				status = "ERROR"
-				result["message"] = msg
-			} else {
-				status = "FINISHED"
-			}
+			result["status"] = "ERROR"
+			result["message"] = msg
+		} else {
+			result["status"] = "FINISHED"
 		}
-	} else {
-		status = "UNKNOWN"
 	}
-	result["status"] = status
 	enc := json.NewEncoder(w)
 	enc.Encode(result)
 }
+
+// Job is stalled, if it is in progress for longer than StallTimeout.
+// Probably the backend has died while processing this job.
+func (conf *config) stalled(job *queue.Job) bool {
+	return job.State == queue.InProgress && conf.StallTimeout > 0 &&
+		timeNow().Sub(job.Changed) > time.Duration(conf.StallTimeout)
+}
+
+// Job has been added by authenticated user.
+// Only then details of unfinished job are shown.
+func ownJob(job *queue.Job, auth *identity) bool {
+	info, err := job.Info()
+	return err == nil && info.User == auth.user
+}
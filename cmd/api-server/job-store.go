@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hknutzen/Netspoc-API/internal/queue"
+)
+
+// Jobs are stored in directories waiting/, inprogress/, ...
+// as known by backend/process-queue.
+type jobStoreConfig struct {
+	Type string
+	// IDs of new jobs are "sequential" (default) or "ulid".
+	IDs string `json:"ids"`
+	// Jobs in directories are indexed in memory. Index is compared
+	// with directories in this interval; default is one minute.
+	ReconcileInterval duration `json:"reconcile_interval"`
+}
+
+// Store of jobs is opened once at startup and isn't changed on reload
+// of config.
+var jobStore queue.Store = queue.NewDirStore("")
+
+func (c *jobStoreConfig) check() error {
+	if c == nil {
+		return nil
+	}
+	switch c.Type {
+	case "", "dir":
+	case "sqlite":
+		// Jobs would stay waiting forever.
+		return fmt.Errorf("'type' \"sqlite\" of 'job_store' can't be used," +
+			" because backend only processes jobs in directories")
+	default:
+		return fmt.Errorf("Unknown 'type' of 'job_store': %q", c.Type)
+	}
+	switch c.IDs {
+	case "", "sequential", "ulid":
+	default:
+		return fmt.Errorf("Unknown 'ids' of 'job_store': %q", c.IDs)
+	}
+	if c.ReconcileInterval < 0 {
+		return fmt.Errorf("'reconcile_interval' must not be negative")
+	}
+	return nil
+}
+
+func openJobStore(c *jobStoreConfig) (queue.Store, error) {
+	var newID func() string
+	interval := time.Minute
+	if c != nil {
+		if c.IDs == "ulid" {
+			newID = queue.NewULID
+		}
+		if c.ReconcileInterval != 0 {
+			interval = time.Duration(c.ReconcileInterval)
+		}
+	}
+	s := queue.NewDirStore("")
+	s.NewID = newID
+	// Advance job counter to highest ID of existing jobs.
+	// Counter may be behind after crash or restore from backup.
+	old, high, err := s.Recover()
+	if err != nil {
+		return nil, err
+	}
+	if high != old {
+		log.Printf("Advanced job-counter from %d to %d", old, high)
+	}
+	return queue.OpenIndex(s, interval)
+}
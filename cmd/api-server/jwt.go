@@ -0,0 +1,360 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Configuration for JSON Web Tokens issued by some OIDC provider.
+// Public keys of provider are read from JWKS file or URL.
+type jwtConfig struct {
+	JWKSFile string `json:"jwks_file"`
+	JWKSURL  string `json:"jwks_url"`
+	Issuer   string
+	Audience string
+	// Name of claim that is used as user name; default is "sub".
+	UserClaim string `json:"user_claim"`
+	// Name of claim with list of groups; default is "groups".
+	GroupsClaim string `json:"groups_claim"`
+	// Map group names to roles.
+	GroupRoles map[string][]string `json:"group_roles"`
+
+	mutex sync.Mutex
+	keys  []*jwk
+	// Time, when keys have been fetched from JWKS URL.
+	fetched time.Time
+}
+
+// Public key from JWKS.
+type jwk struct {
+	Kty string
+	Kid string
+	Alg string
+	Use string
+	N   string
+	E   string
+	Crv string
+	X   string
+	Y   string
+	key crypto.PublicKey
+}
+
+// Accept small differences between clocks of provider and this server.
+const jwtLeeway = time.Minute
+
+// JWKS from URL is fetched again after this time.
+// It is also fetched again if key ID of token is unknown,
+// but not more often than jwksMinRefresh.
+const (
+	jwksMaxAge     = time.Hour
+	jwksMinRefresh = time.Minute
+)
+
+func (conf *config) setupJWT() error {
+	c := conf.JWT
+	if c == nil {
+		return nil
+	}
+	if c.Issuer == "" {
+		return fmt.Errorf("Missing 'issuer' in 'jwt'")
+	}
+	if c.Audience == "" {
+		return fmt.Errorf("Missing 'audience' in 'jwt'")
+	}
+	if c.UserClaim == "" {
+		c.UserClaim = "sub"
+	}
+	if c.GroupsClaim == "" {
+		c.GroupsClaim = "groups"
+	}
+	switch {
+	case c.JWKSFile != "" && c.JWKSURL != "":
+		return fmt.Errorf("Must not use both 'jwks_file' and 'jwks_url'")
+	case c.JWKSFile != "":
+		data, err := os.ReadFile(c.JWKSFile)
+		if err != nil {
+			return fmt.Errorf("Can't read JWKS: %v", err)
+		}
+		keys, err := parseJWKS(data)
+		if err != nil {
+			return fmt.Errorf("Invalid JWKS in %s: %v", c.JWKSFile, err)
+		}
+		c.keys = keys
+	case c.JWKSURL != "":
+		// Keys are fetched on first use.
+	default:
+		return fmt.Errorf("Missing 'jwks_file' or 'jwks_url' in 'jwt'")
+	}
+	return nil
+}
+
+func parseJWKS(data []byte) ([]*jwk, error) {
+	var set struct{ Keys []*jwk }
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	var result []*jwk
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var err error
+		switch k.Kty {
+		case "RSA":
+			k.key, err = rsaKey(k)
+		case "EC":
+			k.key, err = ecKey(k)
+		default:
+			// Ignore unsupported key types.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", k.Kid, err)
+		}
+		result = append(result, k)
+	}
+	if result == nil {
+		return nil, errors.New("no usable keys")
+	}
+	return result, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64 value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func rsaKey(k *jwk) (crypto.PublicKey, error) {
+	n, err := decodeBigInt(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeBigInt(k.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() > 1<<31 {
+		return nil, errors.New("invalid exponent")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func ecKey(k *jwk) (crypto.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, err := decodeBigInt(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeBigInt(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("point is not on curve")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// Get keys, fetch from JWKS URL if needed.
+func (c *jwtConfig) getKeys(kid string) []*jwk {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.JWKSURL == "" {
+		return c.keys
+	}
+	age := time.Since(c.fetched)
+	known := slices.ContainsFunc(c.keys, func(k *jwk) bool {
+		return k.Kid == kid
+	})
+	if age > jwksMaxAge || !known && age > jwksMinRefresh {
+		c.fetched = time.Now()
+		if keys, err := fetchJWKS(c.JWKSURL); err != nil {
+			log.Printf("Can't fetch JWKS: %v", err)
+		} else {
+			c.keys = keys
+		}
+	}
+	return c.keys
+}
+
+func fetchJWKS(url string) ([]*jwk, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(data)
+}
+
+// Authenticate client by JSON Web Token.
+func (conf *config) jwtAuth(token string) (*identity, error) {
+	c := conf.JWT
+	if c == nil {
+		return nil, errors.New("JWT not configured")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	enc := base64.RawURLEncoding
+	hData, err1 := enc.DecodeString(parts[0])
+	pData, err2 := enc.DecodeString(parts[1])
+	sig, err3 := enc.DecodeString(parts[2])
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, fmt.Errorf("malformed token: %v", err)
+	}
+	var header struct{ Alg, Kid string }
+	if err := json.Unmarshal(hData, &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, k := range c.getKeys(header.Kid) {
+		if header.Kid != "" && k.Kid != header.Kid ||
+			k.Alg != "" && k.Alg != header.Alg {
+			continue
+		}
+		if verifyJWTSignature(header.Alg, k.key, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid signature")
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(pData, &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %v", err)
+	}
+	now := time.Now()
+	numericTime := func(name string) (time.Time, bool) {
+		v, ok := claims[name].(float64)
+		return time.Unix(int64(v), 0), ok
+	}
+	if exp, ok := numericTime("exp"); !ok || now.After(exp.Add(jwtLeeway)) {
+		return nil, errors.New("token is expired")
+	}
+	if nbf, ok := numericTime("nbf"); ok && now.Add(jwtLeeway).Before(nbf) {
+		return nil, errors.New("token is not yet valid")
+	}
+	if iss, _ := claims["iss"].(string); iss != c.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	if !slices.Contains(stringList(claims["aud"]), c.Audience) {
+		return nil, errors.New("unexpected audience")
+	}
+	user, _ := claims[c.UserClaim].(string)
+	if user == "" {
+		return nil, fmt.Errorf("missing claim %q", c.UserClaim)
+	}
+	auth := &identity{user: user}
+	for _, g := range stringList(claims[c.GroupsClaim]) {
+		for _, role := range c.GroupRoles[g] {
+			if !slices.Contains(auth.roles, role) {
+				auth.roles = append(auth.roles, role)
+			}
+		}
+	}
+	// Access is granted to configured users
+	// and to members of groups having some role.
+	if _, found := conf.User[user]; !found {
+		if auth.roles == nil {
+			return nil, fmt.Errorf("user %q is not authorized", user)
+		}
+		auth.group = true
+	}
+	return auth, nil
+}
+
+// Value of claim is either a single string or a list of strings.
+func stringList(v any) []string {
+	switch x := v.(type) {
+	case string:
+		return []string{x}
+	case []any:
+		var result []string
+		for _, e := range x {
+			if s, ok := e.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, data, sig []byte) bool {
+	if len(alg) != 5 {
+		return false
+	}
+	var h crypto.Hash
+	switch alg[2:] {
+	case "256":
+		h = crypto.SHA256
+	case "384":
+		h = crypto.SHA384
+	case "512":
+		h = crypto.SHA512
+	default:
+		return false
+	}
+	hasher := h.New()
+	hasher.Write(data)
+	digest := hasher.Sum(nil)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, h, digest, sig) == nil
+		case "PS":
+			return rsa.VerifyPSS(k, h, digest, sig, nil) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func mintJWT(t *testing.T, alg, kid string, key crypto.Signer,
+	claims map[string]any) string {
+
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+	p, _ := json.Marshal(claims)
+	data := b64(h) + "." + b64(p)
+	digest := sha256.Sum256([]byte(data))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return data + "." + b64(sig)
+}
+
+func TestJWT(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwks, _ := json.Marshal(map[string]any{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "r1", "use": "sig",
+				"n": b64(rsaKey.N.Bytes()),
+				"e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "e1", "crv": "P-256",
+				"x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+		},
+	})
+	// Serve JWKS by URL.
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) { w.Write(jwks) }))
+	defer srv.Close()
+
+	claims := func(mod map[string]any) map[string]any {
+		c := map[string]any{
+			"iss":                "https://sso.example.com",
+			"aud":                []string{"other", "netspoc-api"},
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"preferred_username": "u1",
+		}
+		for k, v := range mod {
+			c[k] = v
+		}
+		return c
+	}
+	tests := []struct {
+		title string
+		token string
+		want  string
+	}{
+		{"RSA key",
+			mintJWT(t, "RS256", "r1", rsaKey, claims(nil)),
+			`{"status":"UNKNOWN"}`},
+		{"EC key",
+			mintJWT(t, "ES256", "e1", ecKey, claims(nil)),
+			`{"status":"UNKNOWN"}`},
+		{"Unknown user with role from group",
+			mintJWT(t, "RS256", "r1", rsaKey,
+				claims(map[string]any{
+					"preferred_username": "ci",
+					"groups":             []string{"x", "netspoc-ci"}})),
+			`{"status":"UNKNOWN"}`},
+		{"Unknown user without role",
+			mintJWT(t, "RS256", "r1", rsaKey,
+				claims(map[string]any{
+					"preferred_username": "ci",
+					"groups":             []string{"x"}})),
+			"Invalid token"},
+		{"Bad signature",
+			mintJWT(t, "RS256", "r1", otherKey, claims(nil)),
+			"Invalid token"},
+		{"Algorithm doesn't match key",
+			mintJWT(t, "ES256", "r1", ecKey, claims(nil)),
+			"Invalid token"},
+		{"Expired",
+			mintJWT(t, "RS256", "r1", rsaKey,
+				claims(map[string]any{
+					"exp": time.Now().Add(-time.Hour).Unix()})),
+			"Invalid token"},
+		{"Bad issuer",
+			mintJWT(t, "RS256", "r1", rsaKey,
+				claims(map[string]any{"iss": "https://evil.example.com"})),
+			"Invalid token"},
+		{"Bad audience",
+			mintJWT(t, "RS256", "r1", rsaKey,
+				claims(map[string]any{"aud": "other"})),
+			"Invalid token"},
+	}
+	jwksFile := t.TempDir() + "/jwks.json"
+	os.WriteFile(jwksFile, jwks, 0644)
+	for _, source := range []string{
+		`"jwks_file": "` + jwksFile + `"`,
+		`"jwks_url": "` + srv.URL + `"`,
+	} {
+		setupConfig(t, `
+{"jwt": {
+  `+source+`,
+  "issuer": "https://sso.example.com",
+  "audience": "netspoc-api",
+  "user_claim": "preferred_username",
+  "group_roles": {"netspoc-ci": ["user"]}
+ },
+ "user": {"u1": {}}
+}`)
+		for _, tc := range tests {
+			t.Run(tc.title, func(t *testing.T) {
+				resetFailures()
+				resp := call("/job-status", "Bearer "+tc.token, `{"id": "42"}`)
+				eq(t, tc.want+"\n", resp.Body.String())
+			})
+		}
+	}
+}
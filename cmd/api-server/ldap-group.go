@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	defaultGroupFilter = "(member=%s)"
+	defaultGroupTTL    = 5 * time.Minute
+	// Limit depth of nested groups, to prevent endless search.
+	maxGroupDepth = 10
+)
+
+// Cache roles of users, that have been found from LDAP groups.
+type groupCache struct {
+	mutex   sync.Mutex
+	entries map[string]groupCacheEntry
+}
+
+type groupCacheEntry struct {
+	member  bool
+	roles   []string
+	expires time.Time
+}
+
+func setupLDAPGroups(c *ldapConfig) error {
+	if c.Groups == nil {
+		return nil
+	}
+	if c.GroupBaseDN == "" {
+		return fmt.Errorf("Missing 'group_base_dn' for 'groups' in 'ldap'")
+	}
+	if c.GroupFilter == "" {
+		c.GroupFilter = defaultGroupFilter
+	} else if !strings.Contains(c.GroupFilter, "%s") {
+		return fmt.Errorf("Missing '%%s' in 'group_filter' of 'ldap'")
+	}
+	if c.GroupTTL < 0 {
+		return fmt.Errorf("'group_ttl' in 'ldap' must not be negative")
+	}
+	if c.GroupTTL == 0 {
+		c.GroupTTL = duration(defaultGroupTTL)
+	}
+	for dn := range c.Groups {
+		if _, err := ldap.ParseDN(dn); err != nil {
+			return fmt.Errorf("Invalid DN in 'groups' of 'ldap': %v", err)
+		}
+	}
+	c.groups.entries = make(map[string]groupCacheEntry)
+	return nil
+}
+
+// Find roles of user from configured LDAP groups, where user is
+// member of. Result member tells, if user is member of any
+// configured group, even if no roles are given for this group.
+// Connection l has already been bound as user.
+func ldapRoles(c *ldapConfig, l ldapConn, user, dn string,
+) (roles []string, member bool, err error) {
+
+	if c.Groups == nil {
+		return nil, false, nil
+	}
+	cache := &c.groups
+	cache.mutex.Lock()
+	e, found := cache.entries[user]
+	cache.mutex.Unlock()
+	if found && time.Now().Before(e.expires) {
+		return e.roles, e.member, nil
+	}
+	// Search for groups with credentials of service account.
+	if c.BindDN != "" {
+		if err := l.Bind(c.BindDN, c.BindPass); err != nil {
+			return nil, false,
+				fmt.Errorf("LDAP bind as %s failed: %v", c.BindDN, err)
+		}
+	}
+	groups, err := ldapGroups(c, l, dn)
+	if err != nil {
+		return nil, false, err
+	}
+	for gDN, gRoles := range c.Groups {
+		parsed, _ := ldap.ParseDN(gDN)
+		if slices.ContainsFunc(groups, parsed.EqualFold) {
+			member = true
+			for _, r := range gRoles {
+				if !slices.Contains(roles, r) {
+					roles = append(roles, r)
+				}
+			}
+		}
+	}
+	slices.Sort(roles)
+	cache.mutex.Lock()
+	cache.entries[user] = groupCacheEntry{
+		member:  member,
+		roles:   roles,
+		expires: time.Now().Add(time.Duration(c.GroupTTL)),
+	}
+	cache.mutex.Unlock()
+	return roles, member, nil
+}
+
+// Find DNs of all groups having dn as member.
+// If nested groups are enabled, groups having these groups as
+// member are found as well.
+func ldapGroups(c *ldapConfig, l ldapConn, dn string) ([]*ldap.DN, error) {
+	var result []*ldap.DN
+	seen := map[string]bool{strings.ToLower(dn): true}
+	todo := []string{dn}
+	for depth := 0; len(todo) > 0 && depth < maxGroupDepth; depth++ {
+		var next []string
+		for _, member := range todo {
+			req := ldap.NewSearchRequest(
+				c.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+				0, 0, false,
+				strings.ReplaceAll(c.GroupFilter, "%s", ldap.EscapeFilter(member)),
+				[]string{"dn"}, nil)
+			res, err := l.Search(req)
+			if err != nil {
+				return nil, fmt.Errorf("LDAP search of groups failed: %v", err)
+			}
+			for _, e := range res.Entries {
+				key := strings.ToLower(e.DN)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				if parsed, err := ldap.ParseDN(e.DN); err == nil {
+					result = append(result, parsed)
+				}
+				next = append(next, e.DN)
+			}
+		}
+		if !c.Nested {
+			break
+		}
+		todo = next
+	}
+	return result, nil
+}
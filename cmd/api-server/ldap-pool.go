@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"sync/atomic"
+	"time"
+)
+
+// Default limits of LDAP connection pool.
+const (
+	defaultPoolSize = 4
+	defaultMaxConns = 16
+)
+
+// Pool of LDAP connections.
+// At most maxConns connections are in use concurrently.
+// Up to size unused connections are kept open for reuse.
+type ldapPool struct {
+	idle chan ldapConn
+	busy chan struct{}
+	// Pool of old config, connections are no longer kept.
+	closed atomic.Bool
+}
+
+var ldapMetrics = expvar.NewMap("ldap")
+
+var errPoolExhausted = errors.New("all LDAP connections are busy")
+
+func newLDAPPool(size, maxConns int) *ldapPool {
+	return &ldapPool{
+		idle: make(chan ldapConn, size),
+		busy: make(chan struct{}, maxConns),
+	}
+}
+
+// Get idle or new connection.
+// Result reused tells, if connection has been used before.
+func (p *ldapPool) get(c *ldapConfig) (l ldapConn, reused bool, err error) {
+	// Wait for free slot, but not longer than it takes to connect.
+	select {
+	case p.busy <- struct{}{}:
+	case <-time.After(time.Duration(c.ConnectTimeout)):
+		ldapMetrics.Add("pool_exhausted", 1)
+		return nil, false, errPoolExhausted
+	}
+	select {
+	case l = <-p.idle:
+		ldapMetrics.Add("pool_reuses", 1)
+		return l, true, nil
+	default:
+	}
+	ldapMetrics.Add("dials", 1)
+	l, err = dialLDAP(c)
+	if err != nil {
+		<-p.busy
+	}
+	return l, false, err
+}
+
+// Give back connection, that has been taken from pool.
+// Connection is closed, if it isn't healthy or if pool is full.
+func (p *ldapPool) put(l ldapConn, healthy bool) {
+	<-p.busy
+	if healthy && !p.closed.Load() {
+		select {
+		case p.idle <- l:
+			return
+		default:
+		}
+	}
+	l.Close()
+}
+
+// Close all idle connections, when pool is no longer used.
+func (p *ldapPool) close() {
+	p.closed.Store(true)
+	for {
+		select {
+		case l := <-p.idle:
+			l.Close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Configuration for authentication by LDAP.
+// DN of user is either built from template UserDN
+// or searched with Filter below BaseDN.
+// Without any of these, the bare user name is used for binding.
+type ldapConfig struct {
+	URI string
+	// Template of DN, "%s" is replaced by escaped user name.
+	UserDN string `json:"user_dn"`
+	// Optional credentials for search of user DN.
+	// Anonymous search is used if missing.
+	BindDN   string `json:"bind_dn"`
+	BindPass string `json:"bind_pass"`
+	BaseDN   string `json:"base_dn"`
+	// Search filter, "%s" is replaced by escaped user name.
+	Filter string
+	// Use StartTLS with ldap:// URI.
+	StartTLS bool `json:"start_tls"`
+	// Optional bundle of CA certificates to verify LDAP server.
+	// System CAs are used if missing.
+	CAFile         string   `json:"ca_file"`
+	ConnectTimeout duration `json:"connect_timeout"`
+	// Timeout for each LDAP operation.
+	Timeout duration
+	// Map DN of LDAP group to roles.
+	// Members of these groups are authorized,
+	// even if they are not listed in config.
+	Groups map[string][]string
+	// Groups are searched below this DN with filter GroupFilter.
+	GroupBaseDN string `json:"group_base_dn"`
+	// "%s" is replaced by escaped DN of user or group;
+	// default is "(member=%s)".
+	GroupFilter string `json:"group_filter"`
+	// Also find groups that contain groups of user.
+	Nested bool
+	// Time to cache groups of user; default is "5m".
+	GroupTTL duration `json:"group_ttl"`
+	// Number of idle connections kept for reuse; default is 4.
+	PoolSize int `json:"pool_size"`
+	// Maximum number of concurrently used connections; default is 16.
+	MaxConns int `json:"max_conns"`
+
+	tls    *tls.Config
+	groups groupCache
+	pool   *ldapPool
+}
+
+// Default timeouts, if not configured.
+const (
+	ldapConnectTimeout = 5 * time.Second
+	ldapTimeout        = 5 * time.Second
+)
+
+// Subset of methods of *ldap.Conn, that is used here.
+type ldapConn interface {
+	Bind(user, pass string) error
+	UnauthenticatedBind(user string) error
+	Search(*ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// Is replaced in tests.
+var dialLDAP = func(c *ldapConfig) (ldapConn, error) {
+	l, err := ldap.DialURL(c.URI,
+		ldap.DialWithDialer(
+			&net.Dialer{Timeout: time.Duration(c.ConnectTimeout)}),
+		ldap.DialWithTLSConfig(c.tls))
+	if err != nil {
+		return nil, err
+	}
+	l.SetTimeout(time.Duration(c.Timeout))
+	if c.StartTLS {
+		if err := l.StartTLS(c.tls); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (conf *config) setupLDAP() error {
+	c := conf.LDAP
+	if c == nil {
+		c = new(ldapConfig)
+		conf.LDAP = c
+	}
+	switch {
+	case c.URI == "":
+		c.URI = conf.LDAPURI
+	case conf.LDAPURI != "" && conf.LDAPURI != c.URI:
+		return fmt.Errorf("Conflicting values in 'ldap_uri' and 'ldap.uri'")
+	}
+	for _, auth := range conf.User {
+		if auth.LDAP && c.URI == "" {
+			return fmt.Errorf("No 'ldap_uri' has been configured")
+		}
+	}
+	if c.UserDN != "" {
+		if c.Filter != "" {
+			return fmt.Errorf("Must not use both 'user_dn' and 'filter' in 'ldap'")
+		}
+		if !strings.Contains(c.UserDN, "%s") {
+			return fmt.Errorf("Missing '%%s' in 'user_dn' of 'ldap'")
+		}
+	}
+	if c.Filter != "" {
+		if !strings.Contains(c.Filter, "%s") {
+			return fmt.Errorf("Missing '%%s' in 'filter' of 'ldap'")
+		}
+		if c.BaseDN == "" {
+			return fmt.Errorf("Missing 'base_dn' for 'filter' in 'ldap'")
+		}
+	}
+	if c.BindPass != "" && c.BindDN == "" {
+		return fmt.Errorf("Missing 'bind_dn' for 'bind_pass' in 'ldap'")
+	}
+	if c.PoolSize < 0 || c.MaxConns < 0 {
+		return fmt.Errorf("Size of pool in 'ldap' must not be negative")
+	}
+	if c.PoolSize == 0 {
+		c.PoolSize = defaultPoolSize
+	}
+	if c.MaxConns == 0 {
+		c.MaxConns = defaultMaxConns
+	}
+	c.pool = newLDAPPool(c.PoolSize, c.MaxConns)
+	if c.ConnectTimeout < 0 || c.Timeout < 0 {
+		return fmt.Errorf("Timeout in 'ldap' must not be negative")
+	}
+	if c.ConnectTimeout == 0 {
+		c.ConnectTimeout = duration(ldapConnectTimeout)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = duration(ldapTimeout)
+	}
+	if err := setupLDAPGroups(c); err != nil {
+		return err
+	}
+	if c.URI == "" {
+		if c.Groups != nil {
+			return fmt.Errorf("Missing 'uri' for 'groups' in 'ldap'")
+		}
+		return nil
+	}
+	u, err := url.Parse(c.URI)
+	if err != nil {
+		return fmt.Errorf("Invalid 'uri' in 'ldap': %v", err)
+	}
+	switch u.Scheme {
+	case "ldap":
+	case "ldaps":
+		if c.StartTLS {
+			return fmt.Errorf("Must not use 'start_tls' with ldaps://")
+		}
+	default:
+		return fmt.Errorf("Unsupported scheme in 'uri' of 'ldap': %s", c.URI)
+	}
+	c.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	if c.CAFile != "" {
+		data, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return fmt.Errorf("Can't read 'ca_file' of 'ldap': %v", err)
+		}
+		c.tls.RootCAs = x509.NewCertPool()
+		if !c.tls.RootCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("No certificates found in %s", c.CAFile)
+		}
+	}
+	return nil
+}
+
+// Result of successful authentication by LDAP.
+type ldapUser struct {
+	roles []string
+	// User is member of some group in 'groups' of 'ldap'.
+	member bool
+}
+
+// Check password of user by LDAP bind.
+// On success, give roles of user from its LDAP groups.
+// Returns nil if password is wrong or user isn't found.
+// Returns error if LDAP server isn't usable.
+func ldapAuth(c *ldapConfig, user, pass string) (*ldapUser, error) {
+	for retry := true; ; retry = false {
+		l, reused, err := c.pool.get(c)
+		if err != nil {
+			return nil, fmt.Errorf("LDAP connect failed: %v", err)
+		}
+		u, err := ldapCheck(c, l, user, pass)
+		c.pool.put(l, err == nil)
+		// Idle connection may have been closed by server meanwhile.
+		// Try once again with new connection.
+		if err != nil && reused && retry {
+			continue
+		}
+		return u, err
+	}
+}
+
+func ldapCheck(c *ldapConfig, l ldapConn, user, pass string,
+) (*ldapUser, error) {
+	dn, err := ldapUserDN(c, l, user)
+	if dn == "" || err != nil {
+		return nil, err
+	}
+	err = l.Bind(dn, pass)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("LDAP bind failed: %v", err)
+	}
+	roles, member, err := ldapRoles(c, l, user, dn)
+	if err != nil {
+		return nil, err
+	}
+	return &ldapUser{roles: roles, member: member}, nil
+}
+
+// Find DN of user. Returns empty string if user isn't found.
+func ldapUserDN(c *ldapConfig, l ldapConn, user string) (string, error) {
+	if c.UserDN != "" {
+		return strings.ReplaceAll(c.UserDN, "%s", ldap.EscapeDN(user)), nil
+	}
+	if c.Filter == "" {
+		return user, nil
+	}
+	if c.BindDN != "" {
+		if err := l.Bind(c.BindDN, c.BindPass); err != nil {
+			return "", fmt.Errorf("LDAP bind as %s failed: %v", c.BindDN, err)
+		}
+	} else if err := l.UnauthenticatedBind(""); err != nil {
+		// Connection from pool may still be bound as previous user.
+		return "", fmt.Errorf("LDAP anonymous bind failed: %v", err)
+	}
+	req := ldap.NewSearchRequest(
+		c.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, 0, false,
+		strings.ReplaceAll(c.Filter, "%s", ldap.EscapeFilter(user)),
+		[]string{"dn"}, nil)
+	res, err := l.Search(req)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("LDAP search failed: %v", err)
+	}
+	// User name must be unambiguous.
+	if len(res.Entries) != 1 {
+		return "", nil
+	}
+	return res.Entries[0].DN, nil
+}
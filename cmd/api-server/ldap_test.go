@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// In-process stand-in for LDAP server.
+type fakeLDAP struct {
+	// Map from DN to password.
+	users map[string]string
+	// Entries below base DN of search.
+	entries []*ldap.Entry
+	// Log of executed operations.
+	log []string
+	// All connections, that have been opened.
+	conns []fakeConn
+}
+
+type fakeConn struct {
+	srv *fakeLDAP
+	// Connection has been closed by server.
+	broken *bool
+}
+
+func (c fakeConn) Bind(dn, pass string) error {
+	if *c.broken {
+		return ldap.NewError(ldap.ErrorNetwork, nil)
+	}
+	c.srv.log = append(c.srv.log, "bind "+dn)
+	if p, found := c.srv.users[dn]; !found || p != pass {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, nil)
+	}
+	return nil
+}
+
+func (c fakeConn) UnauthenticatedBind(dn string) error {
+	if *c.broken {
+		return ldap.NewError(ldap.ErrorNetwork, nil)
+	}
+	c.srv.log = append(c.srv.log, "bind anonymous")
+	return nil
+}
+
+// Supports only simple filter "(attr=value)".
+func (c fakeConn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	c.srv.log = append(c.srv.log, "search "+req.Filter)
+	attr, value, _ := strings.Cut(strings.Trim(req.Filter, "()"), "=")
+	res := new(ldap.SearchResult)
+	for _, e := range c.srv.entries {
+		if slices.Contains(e.GetAttributeValues(attr), value) &&
+			strings.HasSuffix(e.DN, req.BaseDN) {
+			res.Entries = append(res.Entries, e)
+		}
+	}
+	return res, nil
+}
+
+func (c fakeConn) Close() error { return nil }
+
+func useFakeLDAP(t *testing.T, srv *fakeLDAP) {
+	orig := dialLDAP
+	t.Cleanup(func() { dialLDAP = orig })
+	dialLDAP = func(*ldapConfig) (ldapConn, error) {
+		c := fakeConn{srv, new(bool)}
+		srv.conns = append(srv.conns, c)
+		return c, nil
+	}
+}
+
+func TestLDAP(t *testing.T) {
+	srv := &fakeLDAP{
+		users: map[string]string{
+			"cn=api,ou=services,dc=example":      "api-secret",
+			"uid=u1,ou=people,dc=example":        "secret",
+			`uid=a\,b,ou=people,dc=example`:      "secret",
+			"u1@example":                         "upn",
+			"uid=other,ou=people,dc=example":     "other",
+			"uid=other-dup,ou=people,dc=example": "other",
+		},
+		entries: []*ldap.Entry{
+			ldap.NewEntry("uid=u1,ou=people,dc=example",
+				map[string][]string{"uid": {"u1"}}),
+			ldap.NewEntry("uid=other,ou=people,dc=example",
+				map[string][]string{"uid": {"dup"}}),
+			ldap.NewEntry("uid=other-dup,ou=people,dc=example",
+				map[string][]string{"uid": {"dup"}}),
+		},
+	}
+	useFakeLDAP(t, srv)
+	users := `"user": {
+  "u1": {"ldap": true}, "a,b": {"ldap": true}, "u1@example": {"ldap": true},
+  "*)(uid=*": {"ldap": true}, "dup": {"ldap": true}
+ }`
+	tests := []struct {
+		title, ldapConf, user, pass, want, log string
+	}{
+		{"Bare name", "", "u1@example", "upn", "ok",
+			"bind u1@example"},
+		{"DN template", `"user_dn": "uid=%s,ou=people,dc=example"`,
+			"u1", "secret", "ok",
+			"bind uid=u1,ou=people,dc=example"},
+		{"Escaped DN", `"user_dn": "uid=%s,ou=people,dc=example"`,
+			"a,b", "secret", "ok",
+			`bind uid=a\,b,ou=people,dc=example`},
+		{"Wrong password", `"user_dn": "uid=%s,ou=people,dc=example"`,
+			"u1", "wrong", "Authentication failed",
+			"bind uid=u1,ou=people,dc=example"},
+		{"Search then bind", `"bind_dn": "cn=api,ou=services,dc=example",
+  "bind_pass": "api-secret",
+  "base_dn": "ou=people,dc=example",
+  "filter": "(uid=%s)"`,
+			"u1", "secret", "ok",
+			"bind cn=api,ou=services,dc=example\n" +
+				"search (uid=u1)\n" +
+				"bind uid=u1,ou=people,dc=example"},
+		{"Escaped filter", `"base_dn": "ou=people,dc=example",
+  "filter": "(uid=%s)"`,
+			"*)(uid=*", "star", "Authentication failed",
+			"bind anonymous\n" +
+				`search (uid=\2a\29\28uid=\2a)`},
+		{"Ambiguous name", `"base_dn": "ou=people,dc=example",
+  "filter": "(uid=%s)"`,
+			"dup", "other", "Authentication failed",
+			"bind anonymous\n" +
+				"search (uid=dup)"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.title, func(t *testing.T) {
+			ldapConf := `"uri": "ldap://ldap.example"`
+			if tc.ldapConf != "" {
+				ldapConf += ", " + tc.ldapConf
+			}
+			setupConfig(t, `{"ldap": {`+ldapConf+`}, `+users+`}`)
+			srv.log = nil
+			resp := call("/job-status", "",
+				`{"user": "`+strings.ReplaceAll(tc.user, `"`, `\"`)+
+					`", "pass": "`+tc.pass+`", "id": "42"}`)
+			got := "ok"
+			if resp.Code != http.StatusOK {
+				got = strings.TrimSpace(resp.Body.String())
+			}
+			eq(t, tc.want, got)
+			eq(t, tc.log, strings.Join(srv.log, "\n"))
+		})
+	}
+}
+
+func TestLDAPGroups(t *testing.T) {
+	srv := &fakeLDAP{
+		users: map[string]string{
+			"uid=u1,ou=people,dc=example": "secret",
+			"uid=u2,ou=people,dc=example": "secret",
+			"uid=u3,ou=people,dc=example": "secret",
+		},
+		entries: []*ldap.Entry{
+			ldap.NewEntry("cn=team,ou=groups,dc=example",
+				map[string][]string{"member": {
+					"uid=u1,ou=people,dc=example",
+					"uid=u2,ou=people,dc=example",
+				}}),
+			ldap.NewEntry("cn=admins,ou=groups,dc=example",
+				map[string][]string{"member": {
+					"uid=u2,ou=people,dc=example",
+				}}),
+			ldap.NewEntry("cn=netspoc,ou=groups,dc=example",
+				map[string][]string{"member": {
+					"cn=team,ou=groups,dc=example",
+				}}),
+			ldap.NewEntry("cn=guests,ou=groups,dc=example",
+				map[string][]string{"member": {
+					"uid=u3,ou=people,dc=example",
+				}}),
+		},
+	}
+	useFakeLDAP(t, srv)
+	login := func(user string) (int, []string) {
+		srv.log = nil
+		resp := call("/login", "",
+			`{"user": "`+user+`", "pass": "secret"}`)
+		if resp.Code != http.StatusOK {
+			return resp.Code, nil
+		}
+		var s struct{ Token string }
+		json.Unmarshal(resp.Body.Bytes(), &s)
+		return resp.Code, parseSessionToken(s.Token).Roles
+	}
+	check := func(user string, code int, roles []string, searches int) {
+		t.Helper()
+		gotCode, gotRoles := login(user)
+		if gotCode != code {
+			t.Errorf("%s: want status %d, got %d", user, code, gotCode)
+		}
+		eq(t, strings.Join(roles, ","), strings.Join(gotRoles, ","))
+		n := 0
+		for _, l := range srv.log {
+			if strings.HasPrefix(l, "search") {
+				n++
+			}
+		}
+		if n != searches {
+			t.Errorf("%s: want %d searches, got %d", user, searches, n)
+		}
+	}
+	config := func(nested bool) string {
+		return fmt.Sprintf(`
+{"ldap": {
+  "uri": "ldap://ldap.example",
+  "user_dn": "uid=%%s,ou=people,dc=example",
+  "groups": {
+    "cn=netspoc,ou=groups,dc=example": ["user"],
+    "CN=Admins,ou=groups,dc=example": ["admin", "user"],
+    "cn=guests,ou=groups,dc=example": []
+  },
+  "group_base_dn": "ou=groups,dc=example",
+  "nested": %v
+ }
+}`, nested)
+	}
+	setupConfig(t, config(false))
+	check("u1", http.StatusBadRequest, nil, 1)
+	check("u2", http.StatusOK, []string{"admin", "user"}, 1)
+	// Member of group without roles.
+	check("u3", http.StatusOK, nil, 1)
+	check("u4", http.StatusBadRequest, nil, 0)
+	resp := call("/login", "", `{"user": "u3", "pass": "secret"}`)
+	var s struct{ Token string }
+	json.Unmarshal(resp.Body.Bytes(), &s)
+	resp = call("/logout", "Bearer "+s.Token, `{}`)
+	if resp.Code != http.StatusOK {
+		t.Errorf("Session token of u3 not accepted: %s", resp.Body)
+	}
+	// Result is cached.
+	check("u2", http.StatusOK, []string{"admin", "user"}, 0)
+
+	setupConfig(t, config(true))
+	// Search for groups of u1, groups of cn=team, groups of cn=netspoc.
+	check("u1", http.StatusOK, []string{"user"}, 3)
+	check("u2", http.StatusOK, []string{"admin", "user"}, 4)
+	check("u1", http.StatusOK, []string{"user"}, 0)
+}
+
+func TestAuthCacheAndPool(t *testing.T) {
+	srv := &fakeLDAP{
+		users: map[string]string{"u1": "secret", "u2": "secret"},
+	}
+	useFakeLDAP(t, srv)
+	config := `
+{"ldap_uri": "ldap://ldap.example",
+ "auth_cache_ttl": "1m",
+ "user": {
+  "u1": {"ldap": true},
+  "u2": {"ldap": true},
+  "u3": {"hash": "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS"}
+ }
+}`
+	setupConfig(t, config)
+	check := func(user, pass string, code int, log string) {
+		t.Helper()
+		srv.log = nil
+		resp := call("/job-status", "",
+			`{"user": "`+user+`", "pass": "`+pass+`", "id": "42"}`)
+		if resp.Code != code {
+			t.Errorf("Want status %d, got %d", code, resp.Code)
+		}
+		eq(t, log, strings.Join(srv.log, "\n"))
+	}
+	hits := func() int64 {
+		v, _ := authMetrics.Get("hits").(*expvar.Int)
+		if v == nil {
+			return 0
+		}
+		return v.Value()
+	}
+	check("u1", "secret", http.StatusOK, "bind u1")
+	check("u1", "wrong", http.StatusBadRequest, "bind u1")
+	h := hits()
+	check("u1", "secret", http.StatusOK, "")
+	check("u3", "secret", http.StatusOK, "")
+	check("u3", "secret", http.StatusOK, "")
+	if got := hits() - h; got != 2 {
+		t.Errorf("Want 2 cache hits, got %d", got)
+	}
+	check("u2", "secret", http.StatusOK, "bind u2")
+	// Connection has been reused.
+	if len(srv.conns) != 1 {
+		t.Errorf("Want 1 connection, got %d", len(srv.conns))
+	}
+
+	// Cache is invalidated, when config is reloaded.
+	srv.conns = nil
+	setupConfig(t, config)
+	check("u1", "secret", http.StatusOK, "bind u1")
+	// Idle connection closed by server is replaced by new connection.
+	*srv.conns[0].broken = true
+	check("u2", "secret", http.StatusOK, "bind u2")
+	if len(srv.conns) != 2 {
+		t.Errorf("Want 2 connections, got %d", len(srv.conns))
+	}
+}
+
+func TestLDAPUnavailable(t *testing.T) {
+	// Server accepts connections, but never answers.
+	hanging, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hanging.Close()
+	go func() {
+		for {
+			c, err := hanging.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	// Port without server.
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	for _, addr := range []string{hanging.Addr().String(), closedAddr} {
+		setupConfig(t, `
+{"ldap": {
+  "uri": "ldap://`+addr+`",
+  "connect_timeout": "200ms",
+  "timeout": "200ms"
+ },
+ "user": {"u1": {"ldap": true}}
+}`)
+		start := time.Now()
+		resp := call("/job-status", "",
+			`{"user": "u1", "pass": "secret", "id": "42"}`)
+		if resp.Code != http.StatusServiceUnavailable {
+			t.Errorf("Want status 503, got %d", resp.Code)
+		}
+		eq(t, "LDAP server is unavailable\n", resp.Body.String())
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("Took too long: %v", d)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	setupConfig(t, `{`+adminUsers+`}`)
+	checkResponse(t, "/debug/vars", "", `{"user": "u1", "pass": "secret"}`,
+		http.StatusForbidden, "Role 'admin' is required")
+	resp := call("/debug/vars", adminAuth, `{}`)
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(resp.Body.Bytes(), &vars); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"auth_cache", "ldap"} {
+		if _, found := vars[k]; !found {
+			t.Errorf("Missing %q in metrics", k)
+		}
+	}
+}
+
+// Reused connection is bound anonymously again before search.
+func TestLDAPAnonymousSearch(t *testing.T) {
+	srv := &fakeLDAP{
+		users: map[string]string{"uid=u1,ou=people,dc=example": "secret"},
+		entries: []*ldap.Entry{
+			ldap.NewEntry("uid=u1,ou=people,dc=example",
+				map[string][]string{"uid": {"u1"}}),
+		},
+	}
+	useFakeLDAP(t, srv)
+	setupConfig(t, `{
+ "ldap": {"uri": "ldap://ldap.example",
+          "base_dn": "ou=people,dc=example", "filter": "(uid=%s)"},
+ "user": {"u1": {"ldap": true}}
+}`)
+	for range 2 {
+		srv.log = nil
+		resp := call("/job-status", "", `{"user": "u1", "pass": "secret", "id": "42"}`)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("Want status 200, got %d: %s", resp.Code, resp.Body)
+		}
+		eq(t, "bind anonymous\nsearch (uid=u1)\nbind uid=u1,ou=people,dc=example",
+			strings.Join(srv.log, "\n"))
+	}
+	if len(srv.conns) != 1 {
+		t.Errorf("Want 1 connection, got %d", len(srv.conns))
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Limit guessing of passwords.
+// After Threshold consecutive failures for some user or from some
+// IP address, further attempts are rejected for LockoutTime.
+// Lockout time doubles with each further failure up to
+// MaxLockoutTime.
+type lockoutConfig struct {
+	Threshold      int
+	LockoutTime    duration `json:"lockout_time"`
+	MaxLockoutTime duration `json:"max_lockout_time"`
+}
+
+// Defaults, if not configured.
+const (
+	defaultThreshold      = 5
+	defaultLockoutTime    = 30 * time.Second
+	defaultMaxLockoutTime = 15 * time.Minute
+	// Limit memory used by failure counters.
+	maxFailureEntries = 100000
+)
+
+// Failed authentication is answered not before this time after
+// start of request. This hides, which check has failed.
+// Is changed in tests.
+var failureDelay = time.Second
+
+func (conf *config) setupLockout() error {
+	c := conf.Lockout
+	if c == nil {
+		c = new(lockoutConfig)
+		conf.Lockout = c
+	}
+	if c.Threshold < 0 || c.LockoutTime < 0 || c.MaxLockoutTime < 0 {
+		return fmt.Errorf("Values in 'lockout' must not be negative")
+	}
+	if c.Threshold == 0 {
+		c.Threshold = defaultThreshold
+	}
+	if c.LockoutTime == 0 {
+		c.LockoutTime = duration(defaultLockoutTime)
+	}
+	if c.MaxLockoutTime == 0 {
+		c.MaxLockoutTime = duration(defaultMaxLockoutTime)
+	}
+	if c.MaxLockoutTime < c.LockoutTime {
+		return fmt.Errorf(
+			"'max_lockout_time' must not be less than 'lockout_time'")
+	}
+	return nil
+}
+
+// Count consecutive failures for some kind of key.
+type failureCounter struct {
+	kind    string
+	mutex   sync.Mutex
+	entries map[string]*failureEntry
+}
+
+type failureEntry struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+var (
+	userFailures = &failureCounter{kind: "user"}
+	addrFailures = &failureCounter{kind: "address"}
+)
+
+// Time until key is no longer locked; zero if not locked.
+func (fc *failureCounter) locked(key string) time.Duration {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	if e := fc.entries[key]; e != nil {
+		return max(0, time.Until(e.until))
+	}
+	return 0
+}
+
+// Count failure for key. Failures, that happened longer than max
+// lockout time ago, are no longer counted.
+func (fc *failureCounter) fail(c *lockoutConfig, key string) {
+	now := time.Now()
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	if fc.entries == nil {
+		fc.entries = make(map[string]*failureEntry)
+	}
+	e := fc.entries[key]
+	if e == nil {
+		// Limit memory used, e.g. by failures with random user names.
+		// Old entries are removed by expireFailures.
+		if len(fc.entries) >= maxFailureEntries {
+			return
+		}
+		e = new(failureEntry)
+		fc.entries[key] = e
+	} else if now.Sub(e.last) > time.Duration(c.MaxLockoutTime) {
+		e.count = 0
+	}
+	e.count++
+	e.last = now
+	if n := e.count - c.Threshold; n >= 0 {
+		d := time.Duration(c.LockoutTime) << min(n, 30)
+		if d <= 0 || d > time.Duration(c.MaxLockoutTime) {
+			d = time.Duration(c.MaxLockoutTime)
+		}
+		e.until = now.Add(d)
+		log.Printf("Locked %s %q for %v after %d failed attempts",
+			fc.kind, key, d, e.count)
+	}
+}
+
+func (fc *failureCounter) reset(key string) {
+	fc.mutex.Lock()
+	delete(fc.entries, key)
+	fc.mutex.Unlock()
+}
+
+// Remove entries without failures for longer than limit.
+func (fc *failureCounter) expire(limit time.Duration) {
+	now := time.Now()
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	for k, e := range fc.entries {
+		if now.Sub(e.last) > limit && now.After(e.until) {
+			delete(fc.entries, k)
+		}
+	}
+}
+
+// Remove old entries of failure counters periodically.
+// Max lockout time is taken from current config on each run.
+func expireFailures() {
+	for {
+		time.Sleep(time.Minute)
+		d := time.Duration(current.Load().Lockout.MaxLockoutTime)
+		userFailures.expire(d)
+		addrFailures.expire(d)
+	}
+}
+
+// Reset failure counters of user and address of client after
+// successful authentication.
+func authSucceeded(user, addr string) {
+	if user != "" {
+		userFailures.reset(user)
+	}
+	addrFailures.reset(addr)
+}
+
+// Check if user or address of client is locked.
+// Sends answer with status 429 if locked.
+func checkLocked(w http.ResponseWriter, user, addr string) bool {
+	d := addrFailures.locked(addr)
+	if user != "" {
+		d = max(d, userFailures.locked(user))
+	}
+	if d == 0 {
+		return false
+	}
+	secs := int((d + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, "Too many failed attempts, try again later",
+		http.StatusTooManyRequests)
+	return true
+}
+
+// Count failed authentication, wait until failureDelay has passed
+// since start and send uniform error message.
+func (conf *config) authFailed(w http.ResponseWriter, user, addr string,
+	start time.Time, fail func(http.ResponseWriter, string), m string) {
+
+	if user != "" {
+		userFailures.fail(conf.Lockout, user)
+	}
+	addrFailures.fail(conf.Lockout, addr)
+	time.Sleep(failureDelay - time.Since(start))
+	fail(w, m)
+}
+
+var dummyHash struct {
+	sync.Once
+	hash []byte
+}
+
+// Compare password with some hash, such that checking an unknown
+// user takes the same time as checking a known user.
+func compareDummyHash(pass string) {
+	dummyHash.Do(func() {
+		dummyHash.hash, _ = bcrypt.GenerateFromPassword(
+			[]byte("dummy"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash.hash, []byte(pass))
+}
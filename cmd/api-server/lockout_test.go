@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockout(t *testing.T) {
+	setupConfig(t, `{"lockout": {"threshold": 3, "lockout_time": "1m"},
+ `+adminUsers+`}`)
+	post := func(addr, user, pass string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/job-status",
+			strings.NewReader(
+				`{"user": "`+user+`", "pass": "`+pass+`", "id": "42"}`))
+		req.RemoteAddr = addr + ":1234"
+		resp := httptest.NewRecorder()
+		handleRequest(resp, req)
+		return resp
+	}
+	check := func(addr, user, pass string, code int) {
+		t.Helper()
+		if resp := post(addr, user, pass); resp.Code != code {
+			t.Errorf("Want status %d, got %d: %s",
+				code, resp.Code, resp.Body.String())
+		}
+	}
+	// Success resets counter of user.
+	check("10.1.1.1", "u1", "wrong", http.StatusBadRequest)
+	check("10.1.1.1", "u1", "wrong", http.StatusBadRequest)
+	check("10.1.1.2", "u1", "secret", http.StatusOK)
+	check("10.1.1.3", "u1", "wrong", http.StatusBadRequest)
+	check("10.1.1.3", "u1", "wrong", http.StatusBadRequest)
+	// Third failure locks user u1 and address 10.1.1.1.
+	check("10.1.1.1", "u1", "wrong", http.StatusBadRequest)
+	resp := post("10.1.1.4", "u1", "secret")
+	if resp.Code != http.StatusTooManyRequests {
+		t.Errorf("Want status 429, got %d", resp.Code)
+	}
+	eq(t, "60", resp.Header().Get("Retry-After"))
+	check("10.1.1.1", "admin", "secret", http.StatusTooManyRequests)
+	check("10.1.1.4", "admin", "secret", http.StatusOK)
+
+	// Success resets counter of address.
+	check("10.1.1.7", "u8", "secret", http.StatusBadRequest)
+	check("10.1.1.7", "u9", "secret", http.StatusBadRequest)
+	check("10.1.1.7", "admin", "secret", http.StatusOK)
+	check("10.1.1.7", "u8", "secret", http.StatusBadRequest)
+	check("10.1.1.7", "u9", "secret", http.StatusBadRequest)
+	check("10.1.1.7", "admin", "secret", http.StatusOK)
+
+	// Failure is answered after failureDelay.
+	failureDelay = 200 * time.Millisecond
+	defer func() { failureDelay = 0 }()
+	start := time.Now()
+	check("10.1.1.6", "u9", "secret", http.StatusBadRequest)
+	if d := time.Since(start); d < failureDelay {
+		t.Errorf("Answered too early after %v", d)
+	}
+}
+
+func TestFailureCounter(t *testing.T) {
+	c := &lockoutConfig{
+		Threshold:      2,
+		LockoutTime:    duration(time.Minute),
+		MaxLockoutTime: duration(time.Hour),
+	}
+	fc := &failureCounter{kind: "test"}
+	fc.fail(c, "a")
+	// Failure long ago isn't counted.
+	fc.entries["a"].last = time.Now().Add(-2 * time.Hour)
+	fc.fail(c, "a")
+	if fc.locked("a") != 0 {
+		t.Error("Must not count failure after max lockout time")
+	}
+	fc.fail(c, "a")
+	if fc.locked("a") == 0 {
+		t.Error("Must lock after consecutive failures")
+	}
+	// No new entries are added at limit.
+	for i := range maxFailureEntries {
+		fc.fail(c, strconv.Itoa(i))
+	}
+	if n := len(fc.entries); n != maxFailureEntries {
+		t.Errorf("Want %d entries, got %d", maxFailureEntries, n)
+	}
+	// Old entries are removed.
+	for _, e := range fc.entries {
+		e.last = time.Now().Add(-2 * time.Hour)
+		e.until = e.last
+	}
+	fc.entries["a"].last = time.Now()
+	fc.expire(time.Hour)
+	if n := len(fc.entries); n != 1 {
+		t.Errorf("Want 1 entry, got %d", n)
+	}
+}
@@ -2,43 +2,150 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net/http"
+	"net/netip"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/go-ldap/ldap/v3"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/hknutzen/Netspoc-API/internal/pwhash"
 )
 
 var confFile = "config"
 
 type config struct {
-	LDAPURI string `json:"ldap_uri"`
-	User    map[string]struct {
-		LDAP bool
-		Hash string
-	}
+	LDAPURI    string `json:"ldap_uri"`
+	LDAP       *ldapConfig
+	SessionTTL duration `json:"session_ttl"`
+	// Time to cache successfully verified passwords; default is off.
+	AuthCacheTTL duration `json:"auth_cache_ttl"`
+	JWT          *jwtConfig
+	TLS          *tlsConfig
+	Lockout      *lockoutConfig
+	// Name of header with name of user, that has already been
+	// authenticated by reverse proxy.
+	IdentityHeader string `json:"identity_header"`
+	// IP addresses or networks of trusted reverse proxies.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// Number of addresses in header X-Forwarded-For, that are
+	// trusted, if request comes from trusted proxy.
+	ForwardedHops int         `json:"forwarded_hops"`
+	HashPolicy    *hashPolicy `json:"hash_policy"`
+	// Attributes of job, that are removed before job is stored.
+	SensitiveFields []string `json:"sensitive_fields"`
+	// Check for changes of config file in this interval;
+	// default is to reload only on SIGHUP.
+	ReloadInterval duration        `json:"reload_interval"`
+	JobStore       *jobStoreConfig `json:"job_store"`
+	// Job in progress for longer than this is reported as STALLED;
+	// default is off.
+	StallTimeout duration `json:"stall_timeout"`
+	User         map[string]*userConfig
+	// Map from hash of API token to token. Is filled in loadConfig.
+	tokens map[string]*apiToken
+	// Parsed value of TrustedProxies.
+	proxyNets []netip.Prefix
+	authCache *authCache
+}
+
+type userConfig struct {
+	LDAP   bool
+	Hash   string
+	Tokens []*apiToken
+	// Optional list of IP addresses and networks,
+	// from where this user is allowed to access.
+	AllowedNetworks []string `json:"allowed_networks"`
+	// Optional list of job methods, this user is allowed to use.
+	Methods  []string
+	Roles    []string
+	Disabled bool
+
+	allowedNets []netip.Prefix
 }
 
-var conf config
+// Current config. It is replaced as a whole, when config file is
+// loaded again. Each request uses the config, that was current at
+// start of request.
+var current atomic.Pointer[config]
+
+// Serialize loading of config file.
+var loadMutex sync.Mutex
+
+// Duration is given as string like "90s", "5m" or "1h" in config.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
 
 func main() {
+	check := flag.String("check-config", "",
+		"Check config `file`, print summary of users and exit")
+	flag.Parse()
+	if *check != "" {
+		if !checkConfig(*check, os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
 	// Start in home directory to find
 	// config file in ./config
 	os.Chdir(os.Getenv("HOME"))
 	if err := loadConfig(); err != nil {
 		log.Fatal(err)
 	}
-	http.HandleFunc("/", handleRequest)
+	if err := initSessions(); err != nil {
+		log.Fatal(err)
+	}
+	store, err := openJobStore(current.Load().JobStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+	jobStore = store
+	go watchConfig()
+	go expireFailures()
+	// Don't use http.DefaultServeMux, because package expvar
+	// registers /debug/vars there without authentication.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleRequest)
 	port := os.Getenv("LISTENPORT")
 	if port == "" {
 		log.Fatal(`Error: missing environment variable "LISTENPORT"`)
 	}
 	bind := os.Getenv("LISTENADDRESS") + ":" + port
+	if os.Getenv("LISTENTLS") != "" {
+		srvTLS, err := newServerTLS()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go reloadOnHUP(srvTLS)
+		server := &http.Server{
+			Addr: bind, Handler: mux, TLSConfig: srvTLS.config()}
+		log.Print("Listening with TLS on ", bind)
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
+	go reloadOnHUP(nil)
 	log.Print("Listening on ", bind)
-	log.Fatal(http.ListenAndServe(bind, nil))
+	log.Fatal(http.ListenAndServe(bind, mux))
 }
 
 func handleRequest(w http.ResponseWriter, r *http.Request) {
@@ -52,15 +159,35 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 		badRequest(w, "Invalid JSON: "+err.Error())
 		return
 	}
-	if authenticate(w, job) {
-		switch r.URL.Path {
-		case "/add-job":
-			addJob(w, body)
-		case "/job-status":
-			jobStatus(w, job)
-		default:
-			badRequest(w, "Unknown path")
+	conf := current.Load()
+	auth := conf.authenticate(w, r, job)
+	if auth == nil {
+		return
+	}
+	switch r.URL.Path {
+	case "/add-job":
+		conf.addJob(w, r, body, auth)
+	case "/job-status":
+		conf.jobStatus(w, job, auth)
+	case "/login":
+		conf.login(w, auth)
+	case "/logout":
+		logout(w, r)
+	case "/add-user", "/delete-user", "/disable-user", "/enable-user",
+		"/reset-password", "/set-user":
+		conf.manageUser(w, r, body, auth)
+	case "/change-password":
+		conf.changePassword(w, r, body, auth)
+	case "/requeue-job", "/fail-job":
+		conf.manageJob(w, r, job, auth)
+	case "/debug/vars":
+		if !conf.hasRole(auth, adminRole) {
+			forbidden(w, "Role 'admin' is required")
+			return
 		}
+		expvar.Handler().ServeHTTP(w, r)
+	default:
+		badRequest(w, "Unknown path")
 	}
 }
 
@@ -70,66 +197,281 @@ type jsonArgs struct {
 	Id   string
 }
 
-func authenticate(w http.ResponseWriter, job jsonArgs) bool {
+// Authenticated client of current request.
+type identity struct {
+	user  string
+	roles []string
+	// Job methods allowed for this client; all methods if empty.
+	methods []string
+	// Client has been authenticated by session token.
+	session bool
+	// User isn't listed in config, but is authorized as member
+	// of some group from LDAP or JWT.
+	group bool
+}
+
+// Authenticate client either by identity header of trusted proxy,
+// by credentials or token from header "Authorization",
+// by client certificate or by attributes 'user' and 'pass' from body.
+// Returns nil, if authentication failed and error has been sent.
+func (conf *config) authenticate(
+	w http.ResponseWriter, r *http.Request, job jsonArgs) *identity {
+
+	if h := conf.IdentityHeader; h != "" && r.Header.Get(h) != "" {
+		return conf.proxyAuth(w, r, job)
+	}
+	if h := r.Header.Get("Authorization"); h != "" {
+		scheme, cred, _ := strings.Cut(h, " ")
+		switch strings.ToLower(scheme) {
+		case "bearer":
+			return conf.tokenAuth(w, r, strings.TrimSpace(cred), job)
+		case "basic":
+			user, pass, ok := r.BasicAuth()
+			if !ok || user == "" || pass == "" {
+				unauthorized(w, "Invalid basic authorization")
+				return nil
+			}
+			if job.User != "" && job.User != user ||
+				job.Pass != "" && job.Pass != pass {
+				badRequest(w,
+					"Credentials in body conflict with header 'Authorization'")
+				return nil
+			}
+			return conf.passwordAuth(w, r, user, pass, unauthorized)
+		default:
+			unauthorized(w, "Unsupported authorization scheme")
+			return nil
+		}
+	}
+	if user := conf.certUser(r); user != "" && job.Pass == "" {
+		if job.User != "" && job.User != user {
+			badRequest(w, "Attribute 'user' doesn't match client certificate")
+			return nil
+		}
+		if !conf.checkAccess(w, r, user) {
+			return nil
+		}
+		return &identity{user: user}
+	}
 	user := job.User
 	if user == "" {
-		badRequest(w, "Missing 'user'")
-		return false
+		unauthorized(w, "Missing 'user'")
+		return nil
 	}
 	pass := job.Pass
 	if pass == "" {
-		badRequest(w, "Missing 'pass'")
-		return false
+		unauthorized(w, "Missing 'pass'")
+		return nil
+	}
+	return conf.passwordAuth(w, r, user, pass, badRequest)
+}
+
+// Check password of user.
+// Function fail is used to send error message, if check fails.
+// All failures are answered with the same message after the same
+// time, to prevent enumeration of users.
+func (conf *config) passwordAuth(
+	w http.ResponseWriter, r *http.Request, user, pass string,
+	fail func(http.ResponseWriter, string)) *identity {
+
+	start := time.Now()
+	// Check access before password, otherwise the different answer
+	// would tell if password is correct.
+	if !conf.checkAccess(w, r, user) {
+		return nil
+	}
+	addr := conf.clientAddr(r).String()
+	if checkLocked(w, user, addr) {
+		return nil
+	}
+	failed := func() {
+		conf.authFailed(w, user, addr, start, fail, "Authentication failed")
 	}
 	userConf, found := conf.User[user]
 	if !found {
-		badRequest(w, "User is not authorized")
-		return false
+		// Members of LDAP groups are authorized without being listed.
+		if conf.LDAP.Groups == nil {
+			compareDummyHash(pass)
+			failed()
+			return nil
+		}
+		userConf = &userConfig{LDAP: true}
 	}
+	if auth := conf.authCache.lookup(user, pass); auth != nil {
+		authSucceeded(user, addr)
+		return auth
+	}
+	auth := &identity{user: user}
 	if hash := userConf.Hash; hash != "" {
-		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
-			badRequest(w, "Local authentication failed")
-			return false
+		if ok, _ := pwhash.Verify(hash, []byte(pass)); !ok {
+			failed()
+			return nil
 		}
+		conf.rehash(user, hash, pass)
 	} else if userConf.LDAP {
-		l, err := ldap.DialURL(conf.LDAPURI)
+		u, err := ldapAuth(conf.LDAP, user, pass)
 		if err != nil {
-			internalErr(w, "LDAP connect failed: "+err.Error())
-			return false
+			log.Print(err)
+			unavailable(w, "LDAP server is unavailable")
+			return nil
 		}
-		defer l.Close()
-		if l.Bind(user, pass) != nil {
-			badRequest(w, "LDAP authentication failed")
-			return false
+		if u == nil || !found && !u.member {
+			failed()
+			return nil
 		}
+		auth.roles = u.roles
+		auth.group = !found
 	} else {
-		internalErr(w, "No authentication method configured")
+		log.Printf("No authentication method configured for user %q", user)
+		failed()
+		return nil
+	}
+	authSucceeded(user, addr)
+	conf.authCache.store(user, pass, auth)
+	return auth
+}
+
+// Check that user isn't disabled and that client connects from
+// allowed networks of user. Sends answer with status 403 otherwise.
+func (conf *config) checkAccess(
+	w http.ResponseWriter, r *http.Request, user string) bool {
+
+	u := conf.User[user]
+	if u == nil {
+		return true
+	}
+	if u.Disabled {
+		forbidden(w, "User is disabled")
 		return false
 	}
+	if u.allowedNets != nil {
+		if a := conf.clientAddr(r); !containsAddr(u.allowedNets, a) {
+			forbidden(w, "Access from "+a.String()+" not allowed")
+			return false
+		}
+	}
 	return true
 }
 
+// Read config file and make it current config.
+// Current config is left unchanged, if new config is invalid.
 func loadConfig() error {
-	bytes, err := os.ReadFile(confFile)
+	loadMutex.Lock()
+	defer loadMutex.Unlock()
+	conf, err := readConfig(confFile)
 	if err != nil {
-		return fmt.Errorf("Can't %s ", err)
+		return err
 	}
-	err = json.Unmarshal(bytes, &conf)
+	if old := current.Swap(conf); old != nil {
+		old.close()
+	}
+	conf.logWeakHashes()
+	return nil
+}
+
+func readConfig(file string) (*config, error) {
+	bytes, err := os.ReadFile(file)
 	if err != nil {
-		return fmt.Errorf("error while reading %s: %s", confFile, err)
+		return nil, fmt.Errorf("Can't %s ", err)
 	}
-	for _, auth := range conf.User {
-		if auth.LDAP && conf.LDAPURI == "" {
-			return fmt.Errorf("No 'ldap_uri' has been configured")
+	return parseConfig(file, bytes)
+}
+
+// Decode content of config file and check values.
+func parseConfig(file string, data []byte) (*config, error) {
+	conf := new(config)
+	err := json.Unmarshal(data, conf)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading %s: %s", file, err)
+	}
+	if err := conf.setup(); err != nil {
+		conf.close()
+		return nil, err
+	}
+	return conf, nil
+}
+
+// Check values of config and fill derived values.
+// All problems found are returned together.
+func (conf *config) setup() error {
+	var errs []error
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	if conf.AuthCacheTTL < 0 {
+		add(fmt.Errorf("'auth_cache_ttl' must not be negative"))
+	}
+	conf.authCache = newAuthCache(time.Duration(conf.AuthCacheTTL))
+	add(conf.setupLDAP())
+	var err error
+	conf.proxyNets, err = parsePrefixes(conf.TrustedProxies)
+	if err != nil {
+		add(fmt.Errorf("Invalid 'trusted_proxies': %v", err))
+	} else if conf.IdentityHeader != "" && conf.proxyNets == nil {
+		add(fmt.Errorf("Missing 'trusted_proxies' for 'identity_header'"))
+	}
+	if conf.ForwardedHops < 0 {
+		add(fmt.Errorf("'forwarded_hops' must not be negative"))
+	}
+	for _, user := range slices.Sorted(maps.Keys(conf.User)) {
+		uConf := conf.User[user]
+		if h := uConf.Hash; h != "" {
+			if err := pwhash.Check(h); err != nil {
+				add(fmt.Errorf("Invalid 'hash' of user '%s': %v", user, err))
+			}
+		}
+		if uConf.AllowedNetworks == nil {
+			continue
+		}
+		uConf.allowedNets, err = parsePrefixes(uConf.AllowedNetworks)
+		if err != nil {
+			add(fmt.Errorf("Invalid 'allowed_networks' of user '%s': %v",
+				user, err))
+		} else if uConf.allowedNets == nil {
+			add(fmt.Errorf("Empty 'allowed_networks' of user '%s'", user))
+		}
+	}
+	if conf.ReloadInterval < 0 {
+		add(fmt.Errorf("'reload_interval' must not be negative"))
+	}
+	add(conf.JobStore.check())
+	if conf.StallTimeout < 0 {
+		add(fmt.Errorf("'stall_timeout' must not be negative"))
+	}
+	add(conf.setupHashPolicy())
+	add(conf.setupLockout())
+	add(conf.setupTokens())
+	add(conf.setupJWT())
+	return errors.Join(errs...)
+}
+
+// Release resources of config, that is no longer current.
+func (conf *config) close() {
+	if conf.LDAP != nil && conf.LDAP.pool != nil {
+		conf.LDAP.pool.close()
+	}
 }
 
 func badRequest(w http.ResponseWriter, m string) {
 	http.Error(w, m, http.StatusBadRequest)
 }
 
+func unauthorized(w http.ResponseWriter, m string) {
+	w.Header().Add("WWW-Authenticate", `Basic realm="Netspoc-API"`)
+	w.Header().Add("WWW-Authenticate", `Bearer realm="Netspoc-API"`)
+	http.Error(w, m, http.StatusUnauthorized)
+}
+
+func forbidden(w http.ResponseWriter, m string) {
+	http.Error(w, m, http.StatusForbidden)
+}
+
+func unavailable(w http.ResponseWriter, m string) {
+	http.Error(w, m, http.StatusServiceUnavailable)
+}
+
 func internalErr(w http.ResponseWriter, m string) {
 	http.Error(w, m, http.StatusInternalServerError)
 }
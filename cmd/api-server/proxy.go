@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Parse list of IP addresses and networks in CIDR notation.
+func parsePrefixes(l []string) ([]netip.Prefix, error) {
+	var result []netip.Prefix
+	for _, s := range l {
+		var p netip.Prefix
+		var err error
+		if strings.Contains(s, "/") {
+			p, err = netip.ParsePrefix(s)
+		} else {
+			var a netip.Addr
+			a, err = netip.ParseAddr(s)
+			p = netip.PrefixFrom(a, a.BitLen())
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, p.Masked())
+	}
+	return result, nil
+}
+
+func containsAddr(l []netip.Prefix, a netip.Addr) bool {
+	a = a.Unmap()
+	for _, p := range l {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get IP address of peer of current connection.
+func peerAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	a, _ := netip.ParseAddr(host)
+	return a.Unmap()
+}
+
+// Get IP address of client.
+// If request comes from trusted proxy, up to conf.ForwardedHops
+// addresses are taken from right of header X-Forwarded-For,
+// as long as these are also trusted proxies.
+func (conf *config) clientAddr(r *http.Request) netip.Addr {
+	a := peerAddr(r)
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	n := conf.ForwardedHops
+	for i := len(hops) - 1; i >= 0 && n > 0; i-- {
+		if !containsAddr(conf.proxyNets, a) {
+			break
+		}
+		next, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		a = next.Unmap()
+		n--
+	}
+	return a
+}
+
+// Authenticate client by name of user in identity header, that has
+// been set by trusted reverse proxy. Header from other addresses is
+// rejected.
+func (conf *config) proxyAuth(
+	w http.ResponseWriter, r *http.Request, job jsonArgs) *identity {
+
+	peer := peerAddr(r)
+	if !containsAddr(conf.proxyNets, peer) {
+		forbidden(w, "Header '"+conf.IdentityHeader+
+			"' not allowed from "+peer.String())
+		return nil
+	}
+	user := r.Header.Get(conf.IdentityHeader)
+	if job.User != "" && job.User != user {
+		badRequest(w, "Attribute 'user' doesn't match header '"+
+			conf.IdentityHeader+"'")
+		return nil
+	}
+	if _, found := conf.User[user]; !found {
+		forbidden(w, "User is not authorized")
+		return nil
+	}
+	if !conf.checkAccess(w, r, user) {
+		return nil
+	}
+	return &identity{user: user}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/hknutzen/Netspoc-API/internal/pwhash"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Required strength of password hashes.
+// Weaker hashes are replaced in config after successful login,
+// if Rehash is set. Otherwise users with weak hashes are only logged.
+type hashPolicy struct {
+	// "bcrypt" or "argon2id"; default is "bcrypt".
+	Algorithm  string
+	BcryptCost int `json:"bcrypt_cost"`
+	// Parameters of argon2id, memory is given in KiB.
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	Rehash  bool
+
+	policy pwhash.Policy
+}
+
+// Old hashes, that already have been replaced.
+var rehashed struct {
+	sync.Mutex
+	done map[string]bool
+}
+
+func (conf *config) setupHashPolicy() error {
+	p := conf.HashPolicy
+	if p == nil {
+		return nil
+	}
+	switch p.Algorithm {
+	case "":
+		p.Algorithm = "bcrypt"
+	case "bcrypt", "argon2id":
+	default:
+		return fmt.Errorf("Unknown 'algorithm' in 'hash_policy': %s",
+			p.Algorithm)
+	}
+	if p.BcryptCost == 0 {
+		p.BcryptCost = bcrypt.DefaultCost
+	}
+	if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+		return fmt.Errorf("'bcrypt_cost' must be between %d and %d",
+			bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	def := pwhash.DefaultArgon2
+	a := pwhash.Argon2Params{
+		Memory:  cmp.Or(p.Memory, def.Memory),
+		Time:    cmp.Or(p.Time, def.Time),
+		Threads: cmp.Or(p.Threads, def.Threads),
+	}
+	p.policy = pwhash.Policy{Algorithm: p.Algorithm, Cost: p.BcryptCost, Argon2: a}
+	return nil
+}
+
+// Log users with weak password hash.
+// Is called, when config has become current config.
+func (conf *config) logWeakHashes() {
+	p := conf.HashPolicy
+	if p == nil {
+		return
+	}
+	var weak []string
+	for user, uConf := range conf.User {
+		if uConf.Hash != "" && p.policy.Weak(uConf.Hash) {
+			weak = append(weak, user)
+		}
+	}
+	if weak != nil {
+		slices.Sort(weak)
+		log.Printf("Weak password hash of users: %s", strings.Join(weak, ", "))
+	}
+}
+
+// Replace weak hash of user in config by stronger hash of password.
+// Password has already been verified.
+func (conf *config) rehash(user, old, pass string) {
+	p := conf.HashPolicy
+	if p == nil || !p.Rehash || !p.policy.Weak(old) {
+		return
+	}
+	rehashed.Lock()
+	defer rehashed.Unlock()
+	if rehashed.done[old] {
+		return
+	}
+	hash, err := p.policy.Hash([]byte(pass))
+	if err == nil {
+		err = updateConfigFile(func(data []byte) ([]byte, error) {
+			o, _ := json.Marshal(old)
+			n, _ := json.Marshal(hash)
+			if bytes.Count(data, o) != 1 {
+				return nil, errors.New("old hash not found in config")
+			}
+			return bytes.Replace(data, o, n, 1), nil
+		})
+	}
+	if err != nil {
+		log.Printf("Can't rehash password of user %q: %v", user, err)
+		return
+	}
+	if rehashed.done == nil {
+		rehashed.done = make(map[string]bool)
+	}
+	rehashed.done[old] = true
+	log.Printf("Rehashed password of user %q", user)
+	if err := loadConfig(); err != nil {
+		log.Printf("Can't load rehashed config: %v", err)
+	}
+}
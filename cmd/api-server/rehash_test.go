@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hknutzen/Netspoc-API/internal/pwhash"
+)
+
+func TestRehash(t *testing.T) {
+	h1 := secretHash
+	h2 := "$2a$04$6VLLHw1EUVTL0W1H6033xelNzkcV2ZMwFp3uDqbZRSKeRMIja9NhS"
+	orig := `{
+ "hash_policy": {"bcrypt_cost": 5, "rehash": true},
+ "user": {
+  "u1": {"hash": "` + h1 + `"},
+  "u2": {"hash": "` + h2 + `"}
+ }
+}`
+	setupConfig(t, orig)
+	rehashed.done = nil
+	resp := call("/job-status", "", `{"user": "u1", "pass": "secret", "id": "1"}`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", resp.Code, resp.Body)
+	}
+	bak, _ := os.ReadFile("config.bak")
+	eq(t, orig, string(bak))
+	// Changed config is current config.
+	conf := current.Load()
+	h := conf.User["u1"].Hash
+	if conf.HashPolicy.policy.Weak(h) {
+		t.Errorf("New hash %s is weak", h)
+	}
+	if ok, _ := pwhash.Verify(h, []byte("secret")); !ok {
+		t.Errorf("New hash %s doesn't match password", h)
+	}
+	eq(t, h2, conf.User["u2"].Hash)
+
+	// Don't change config without option "rehash".
+	setupConfig(t, strings.Replace(orig, `, "rehash": true`, "", 1))
+	call("/job-status", "", `{"user": "u1", "pass": "secret", "id": "1"}`)
+	if _, err := os.Stat("config.bak"); err == nil {
+		t.Error("Must not change config without option 'rehash'")
+	}
+}
+
+func TestLogWeakHashes(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+	data := `{"hash_policy": {"bcrypt_cost": 5}, ` + adminUsers + `}`
+	// Only logged, when config is loaded, not when it is checked.
+	if _, err := parseConfig("config", []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	eq(t, "", buf.String())
+	setupConfig(t, data)
+	eq(t, "Weak password hash of users: admin, u1\n", buf.String())
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Load config file and certificates of TLS listener again,
+// when SIGHUP is received. Argument s is nil without TLS listener.
+func reloadOnHUP(s *serverTLS) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		reloadConfig()
+		if s == nil {
+			continue
+		}
+		if err := s.load(); err != nil {
+			log.Print(err)
+		} else {
+			log.Print("Reloaded TLS certificates")
+		}
+	}
+}
+
+// Invalid config is logged and old config is kept.
+func reloadConfig() {
+	if err := loadConfig(); err != nil {
+		log.Printf("Keeping old config: %v", err)
+	} else {
+		log.Print("Reloaded config")
+	}
+}
+
+// Poll for changes of config file, if reload_interval is set.
+// Interval is taken from current config on each check.
+func watchConfig() {
+	last := configStamp()
+	for {
+		d := time.Duration(current.Load().ReloadInterval)
+		if d == 0 {
+			// Check again later, if interval has been set by SIGHUP.
+			time.Sleep(time.Minute)
+			last = configStamp()
+			continue
+		}
+		time.Sleep(d)
+		if s := configStamp(); s != last {
+			last = s
+			reloadConfig()
+		}
+	}
+}
+
+// Identify version of config file by time of modification and size.
+func configStamp() [2]int64 {
+	fi, err := os.Stat(confFile)
+	if err != nil {
+		return [2]int64{}
+	}
+	return [2]int64{fi.ModTime().UnixNano(), fi.Size()}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	setupConfig(t, `{"user": {"u1": {"hash": "`+secretHash+`"}}}`)
+	status := func(user string) int {
+		return call("/job-status", "",
+			`{"user": "`+user+`", "pass": "secret", "id": "1"}`).Code
+	}
+	check := func(user string, code int) {
+		t.Helper()
+		if got := status(user); got != code {
+			t.Errorf("%s: want status %d, got %d", user, code, got)
+		}
+	}
+	// Invalid config is rejected, old config is kept.
+	os.WriteFile("config", []byte(`{"user": {"u1": {"hash": "bad"}}}`), 0600)
+	if loadConfig() == nil {
+		t.Error("Expected error from loadConfig")
+	}
+	check("u1", http.StatusOK)
+
+	os.WriteFile("config", []byte(`{"user": {"u2": {"hash": "`+secretHash+`"}}}`),
+		0600)
+	reloadConfig()
+	check("u1", http.StatusBadRequest)
+	check("u2", http.StatusOK)
+
+	// Each request sees either old or new config.
+	resetFailures()
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				if code := status("u2"); code != http.StatusOK {
+					t.Errorf("Unexpected status %d during reload", code)
+				}
+			}
+		}()
+	}
+	for range 20 {
+		reloadConfig()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session tokens are issued by /login and are valid for a short
+// time. A token consists of base64 encoded JSON data and HMAC of
+// this data, separated by ".".
+// The HMAC key is stored in file sessionKeyFile and is created on
+// first start. IDs of revoked tokens are stored in file
+// revokedFile until token expires.
+const (
+	sessionKeyFile = "session-key"
+	revokedFile    = "revoked-sessions"
+)
+
+const defaultSessionTTL = time.Hour
+
+type sessionClaims struct {
+	ID      string   `json:"jti"`
+	User    string   `json:"user"`
+	Expires int64    `json:"exp"`
+	Methods []string `json:"methods,omitempty"`
+	Roles   []string `json:"roles,omitempty"`
+	Group   bool     `json:"group,omitempty"`
+}
+
+var sessions struct {
+	sync.Mutex
+	key []byte
+	// Map from ID of revoked token to its expiry.
+	revoked map[string]int64
+}
+
+// Read or create key for signing session tokens,
+// read list of revoked tokens.
+func initSessions() error {
+	key, err := os.ReadFile(sessionKeyFile)
+	if os.IsNotExist(err) {
+		key = make([]byte, 32)
+		rand.Read(key)
+		err = writeNewFile(sessionKeyFile, key)
+	}
+	if err != nil {
+		return fmt.Errorf("Can't get session key: %v", err)
+	}
+	if len(key) < 32 {
+		return fmt.Errorf("Session key in %s is too short", sessionKeyFile)
+	}
+	revoked := make(map[string]int64)
+	if fh, err := os.Open(revokedFile); err == nil {
+		now := time.Now().Unix()
+		expired := false
+		scanner := bufio.NewScanner(fh)
+		for scanner.Scan() {
+			var id string
+			var exp int64
+			fmt.Sscan(scanner.Text(), &id, &exp)
+			if exp > now {
+				revoked[id] = exp
+			} else {
+				expired = true
+			}
+		}
+		fh.Close()
+		// Remove expired entries from file.
+		if expired {
+			var b strings.Builder
+			for id, exp := range revoked {
+				fmt.Fprintln(&b, id, exp)
+			}
+			tmp := revokedFile + ".tmp"
+			os.Remove(tmp)
+			if err := writeNewFile(tmp, []byte(b.String())); err != nil {
+				return err
+			}
+			if err := os.Rename(tmp, revokedFile); err != nil {
+				return err
+			}
+		}
+	}
+	sessions.Lock()
+	sessions.key = key
+	sessions.revoked = revoked
+	sessions.Unlock()
+	return nil
+}
+
+// Create file with secret content, fail if file already exists.
+func writeNewFile(name string, data []byte) error {
+	fh, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fh.Write(data); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+func signSession(payload []byte) []byte {
+	sessions.Lock()
+	mac := hmac.New(sha256.New, sessions.key)
+	sessions.Unlock()
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (conf *config) newSessionToken(auth *identity) (string, time.Time) {
+	ttl := time.Duration(conf.SessionTTL)
+	if ttl == 0 {
+		ttl = defaultSessionTTL
+	}
+	exp := time.Now().Add(ttl)
+	b := make([]byte, 16)
+	rand.Read(b)
+	c := sessionClaims{
+		ID:      hex.EncodeToString(b),
+		User:    auth.user,
+		Expires: exp.Unix(),
+		Methods: auth.methods,
+		Roles:   auth.roles,
+		Group:   auth.group,
+	}
+	payload, _ := json.Marshal(c)
+	enc := base64.RawURLEncoding
+	token := enc.EncodeToString(payload) + "." +
+		enc.EncodeToString(signSession(payload))
+	return token, exp
+}
+
+// Check signature, expiry and revocation of session token.
+// Returns nil if token is invalid.
+func parseSessionToken(token string) *sessionClaims {
+	enc := base64.RawURLEncoding
+	p64, s64, found := strings.Cut(token, ".")
+	if !found {
+		return nil
+	}
+	payload, err1 := enc.DecodeString(p64)
+	sig, err2 := enc.DecodeString(s64)
+	if err1 != nil || err2 != nil ||
+		!hmac.Equal(sig, signSession(payload)) {
+		return nil
+	}
+	c := new(sessionClaims)
+	if json.Unmarshal(payload, c) != nil ||
+		time.Now().Unix() >= c.Expires {
+		return nil
+	}
+	sessions.Lock()
+	_, revoked := sessions.revoked[c.ID]
+	sessions.Unlock()
+	if revoked {
+		return nil
+	}
+	return c
+}
+
+// Authenticate client by session token.
+func (conf *config) sessionAuth(token string) *identity {
+	c := parseSessionToken(token)
+	if c == nil {
+		return nil
+	}
+	if _, found := conf.User[c.User]; !found && !c.Group {
+		return nil
+	}
+	return &identity{user: c.User, methods: c.Methods, roles: c.Roles,
+		session: true, group: c.Group}
+}
+
+// Give new session token for already authenticated client.
+// Session token can't be extended by new token, otherwise a stolen
+// token would never expire.
+func (conf *config) login(w http.ResponseWriter, auth *identity) {
+	if auth.session {
+		forbidden(w, "Must not login with session token")
+		return
+	}
+	token, exp := conf.newSessionToken(auth)
+	enc := json.NewEncoder(w)
+	enc.Encode(jsonMap{
+		"token":   token,
+		"expires": exp.Format(time.RFC3339),
+	})
+}
+
+// Revoke session token given in header "Authorization".
+func logout(w http.ResponseWriter, r *http.Request) {
+	_, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	c := parseSessionToken(strings.TrimSpace(token))
+	if c == nil {
+		badRequest(w, "Missing session token")
+		return
+	}
+	sessions.Lock()
+	defer sessions.Unlock()
+	sessions.revoked[c.ID] = c.Expires
+	fh, err := os.OpenFile(
+		revokedFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err == nil {
+		_, err = fmt.Fprintln(fh, c.ID, c.Expires)
+		if err2 := fh.Close(); err == nil {
+			err = err2
+		}
+	}
+	if err != nil {
+		internalErr(w, "Can't store revoked session: "+err.Error())
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.Encode(jsonMap{})
+}
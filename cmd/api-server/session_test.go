@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	setupConfig(t, `{`+adminUsers+`}`)
+	resp := call("/login", "", `{"user": "u1", "pass": "secret"}`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Login failed: %s", resp.Body.String())
+	}
+	var s struct{ Token, Expires string }
+	json.Unmarshal(resp.Body.Bytes(), &s)
+	bearer := "Bearer " + s.Token
+
+	resp = call("/job-status", bearer, `{"id": "42"}`)
+	eq(t, `{"status":"UNKNOWN"}`+"\n", resp.Body.String())
+
+	// Session token isn't renewed.
+	resp = call("/login", bearer, `{}`)
+	eq(t, "Must not login with session token\n", resp.Body.String())
+
+	// Bad signature
+	resp = call("/job-status", bearer+"x", `{"id": "42"}`)
+	eq(t, "Invalid token\n", resp.Body.String())
+
+	// Session key is kept after restart.
+	if err := initSessions(); err != nil {
+		t.Fatal(err)
+	}
+	resp = call("/job-status", bearer, `{"id": "42"}`)
+	eq(t, `{"status":"UNKNOWN"}`+"\n", resp.Body.String())
+
+	resp = call("/logout", bearer, `{}`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Logout failed: %s", resp.Body.String())
+	}
+	resp = call("/job-status", bearer, `{"id": "42"}`)
+	eq(t, "Invalid token\n", resp.Body.String())
+
+	// Revoked token stays revoked after restart.
+	if err := initSessions(); err != nil {
+		t.Fatal(err)
+	}
+	resp = call("/job-status", bearer, `{"id": "42"}`)
+	eq(t, "Invalid token\n", resp.Body.String())
+}
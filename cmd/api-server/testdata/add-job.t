@@ -17,7 +17,9 @@
 --job-counter
 1
 --waiting/1
-{"user": "u1"}
+{"user": "u1",
+ "_netspoc_api": {"user": "u1", "submitted": "2026-01-02T03:04:05Z",
+  "source": "192.0.2.1", "request_id": "42"}}
 =RESPONSE={"id": "1"}
 =STATUS=200
 
@@ -32,23 +34,74 @@
 --job-counter
 1000
 --waiting/1000
-{"user": "u1"}
+{"user": "u1",
+ "_netspoc_api": {"user": "u1", "submitted": "2026-01-02T03:04:05Z",
+  "source": "192.0.2.1", "request_id": "42"}}
 =RESPONSE={"id": "1000"}
 =STATUS=200
 
-=TITLE=Overwrite job with same id
+=TITLE=Don't overwrite job with same id
 =INPUT=
 [[config]]
 --job-counter
 1
 --waiting/2
 {"a": "bc"}
+--finished/3
+{"a": "de"}
 =URL=/add-job
 =REQUEST={"user": "u1", "pass": "secret"}
 =OUTPUT=
 --job-counter
-2
+4
 --waiting/2
-{"user": "u1"}
-=RESPONSE={"id": "2"}
+{"a": "bc"}
+--waiting/4
+{"user": "u1",
+ "_netspoc_api": {"user": "u1", "submitted": "2026-01-02T03:04:05Z",
+  "source": "192.0.2.1", "request_id": "42"}}
+=RESPONSE={"id": "4"}
+=STATUS=200
+
+=TITLE=Reserved attribute
+=INPUT=
+[[config]]
+=URL=/add-job
+=REQUEST={"user": "u1", "pass": "secret", "_netspoc_api": {"user": "u2"}}
+=RESPONSE=
+Attribute '_netspoc_api' is reserved
+=STATUS=400
+
+=TITLE=Remove password and sensitive fields in any case
+=INPUT=
+--config
+{"sensitive_fields": ["token"],
+ "user": {
+  "u1": {
+    "hash": "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS"
+  }
+ }
+}
+=URL=/add-job
+=REQUEST={"user": "u1", "PASS": "secret", "Token": "t", "crq": "c"}
+=OUTPUT=
+--waiting/1
+{"user": "u1", "crq": "c",
+ "_netspoc_api": {"user": "u1", "submitted": "2026-01-02T03:04:05Z",
+  "source": "192.0.2.1", "request_id": "42"}}
+=RESPONSE={"id": "1"}
 =STATUS=200
+
+=TITLE=SQLite store isn't processed by backend
+=INPUT=
+--config
+{"job_store": {"type": "sqlite"}}
+=ERROR=
+'type' "sqlite" of 'job_store' can't be used, because backend only processes jobs in directories
+
+=TITLE=Unknown type of job store
+=INPUT=
+--config
+{"job_store": {"type": "db"}}
+=ERROR=
+Unknown 'type' of 'job_store': "db"
@@ -0,0 +1,132 @@
+=TEMPL=config
+--config
+{"trusted_proxies": ["10.1.1.1"],
+ "forwarded_hops": 1,
+ "user": {
+  "u1": {
+    "hash": "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS",
+    "allowed_networks": ["10.2.0.0/16", "2001:db8::1"]
+  },
+  "u2": {
+    "hash": "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS"
+  }
+ }
+}
+=END=
+
+=TITLE=Access from allowed network
+=INPUT=
+[[config]]
+=URL=/job-status
+=REMOTE_ADDR=10.2.3.4
+=REQUEST={"user": "u1", "pass": "secret", "id": "42"}
+=RESPONSE={"status": "UNKNOWN"}
+=STATUS=200
+
+=TITLE=Access from allowed IPv6 address
+=INPUT=
+[[config]]
+=URL=/job-status
+=REMOTE_ADDR=[2001:db8::1]
+=REQUEST={"user": "u1", "pass": "secret", "id": "42"}
+=RESPONSE={"status": "UNKNOWN"}
+=STATUS=200
+
+=TITLE=Access from other network is rejected
+=INPUT=
+[[config]]
+=URL=/job-status
+=REMOTE_ADDR=10.3.3.4
+=REQUEST={"user": "u1", "pass": "secret", "id": "42"}
+=RESPONSE=
+Access from 10.3.3.4 not allowed
+=STATUS=403
+
+=TITLE=Wrong password from other network gets same answer
+=INPUT=
+[[config]]
+=URL=/job-status
+=REMOTE_ADDR=10.3.3.4
+=REQUEST={"user": "u1", "pass": "wrong", "id": "42"}
+=RESPONSE=
+Access from 10.3.3.4 not allowed
+=STATUS=403
+
+=TITLE=User without allowed networks has no restriction
+=INPUT=
+[[config]]
+=URL=/job-status
+=REMOTE_ADDR=10.3.3.4
+=REQUEST={"user": "u2", "pass": "secret", "id": "42"}
+=RESPONSE={"status": "UNKNOWN"}
+=STATUS=200
+
+=TITLE=Address from X-Forwarded-For of trusted proxy
+=INPUT=
+[[config]]
+=URL=/job-status
+=REMOTE_ADDR=10.1.1.1
+=HEADER=X-Forwarded-For: 10.2.3.4
+=REQUEST={"user": "u1", "pass": "secret", "id": "42"}
+=RESPONSE={"status": "UNKNOWN"}
+=STATUS=200
+
+=TITLE=Only trusted number of hops is used
+=INPUT=
+[[config]]
+=URL=/job-status
+=REMOTE_ADDR=10.1.1.1
+=HEADER=X-Forwarded-For: 10.2.3.4, 10.3.3.4
+=REQUEST={"user": "u1", "pass": "secret", "id": "42"}
+=RESPONSE=
+Access from 10.3.3.4 not allowed
+=STATUS=403
+
+=TITLE=X-Forwarded-For from untrusted address is ignored
+=INPUT=
+[[config]]
+=URL=/job-status
+=REMOTE_ADDR=10.3.3.4
+=HEADER=X-Forwarded-For: 10.2.3.4
+=REQUEST={"user": "u1", "pass": "secret", "id": "42"}
+=RESPONSE=
+Access from 10.3.3.4 not allowed
+=STATUS=403
+
+=TITLE=Invalid X-Forwarded-For is ignored
+=INPUT=
+[[config]]
+=URL=/job-status
+=REMOTE_ADDR=10.1.1.1
+=HEADER=X-Forwarded-For: unknown
+=REQUEST={"user": "u1", "pass": "secret", "id": "42"}
+=RESPONSE=
+Access from 10.1.1.1 not allowed
+=STATUS=403
+
+=TITLE=Invalid allowed network
+=INPUT=
+--config
+{"user": {"u1": {"hash": "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS", "allowed_networks": ["10.2.x.0/16"]}}}
+=URL=/job-status
+=REQUEST={"id": "42"}
+=ERROR=
+Invalid 'allowed_networks' of user 'u1': netip.ParsePrefix("10.2.x.0/16"): ParseAddr("10.2.x.0"): unexpected character (at "x.0")
+
+=TITLE=Empty allowed networks
+=INPUT=
+--config
+{"user": {"u1": {"hash": "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS", "allowed_networks": []}}}
+=URL=/job-status
+=REQUEST={"id": "42"}
+=ERROR=
+Empty 'allowed_networks' of user 'u1'
+
+=TITLE=Negative forwarded hops
+=INPUT=
+--config
+{"forwarded_hops": -1}
+=URL=/job-status
+=REQUEST={"id": "42"}
+=ERROR=
+'forwarded_hops' must not be negative
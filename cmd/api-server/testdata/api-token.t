@@ -0,0 +1,155 @@
+=TEMPL=config
+--config
+{"user": {
+  "u1": {
+    "hash": "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS",
+    "tokens": [
+      {"hash": "80b3ad2d438bfafa1ea690c5a59f54548dcc76ad6a839c6704ac1d9d565d9c80",
+       "expires": "2099-12-31",
+       "description": "CI system"
+      },
+      {"hash": "8690c3af015e6294ff67fcfd334f181a4704b579e9b2bce79ec9716ac62da2d6",
+       "expires": "2099-12-31",
+       "description": "Only add and delete",
+       "methods": ["add", "delete", "multi_job"]
+      }
+    ]
+  },
+  "u2": {
+    "tokens": [
+      {"hash": "fa64ea1e82e1206f828ab2a02917c7e92accb98e3b95881a1b4ad52b914b66e3",
+       "expires": "2020-01-01"
+      }
+    ]
+  }
+ }
+}
+=END=
+
+=TITLE=Add job with token
+=INPUT=
+[[config]]
+=URL=/add-job
+=HEADER=Authorization: Bearer tok1
+=REQUEST={"method": "set", "params": {}}
+=OUTPUT=
+--job-counter
+1
+--waiting/1
+{"method": "set", "params": {},
+ "_netspoc_api": {"user": "u1", "submitted": "2026-01-02T03:04:05Z",
+  "source": "192.0.2.1", "request_id": "42"}}
+=RESPONSE={"id": "1"}
+=STATUS=200
+
+=TITLE=Job status with token
+=INPUT=
+[[config]]
+--finished/42
+{"user": "u1"}
+--result/42
+=URL=/job-status
+=HEADER=Authorization: Bearer tok1
+=REQUEST={"id": "42"}
+=RESPONSE={"status": "FINISHED"}
+=STATUS=200
+
+=TITLE=Token with matching user and ignored password in body
+=INPUT=
+[[config]]
+--waiting/42
+{}
+=URL=/job-status
+=HEADER=Authorization: Bearer tok1
+=REQUEST={"user": "u1", "pass": "wrong", "id": "42"}
+=RESPONSE={"status": "WAITING"}
+=STATUS=200
+
+=TITLE=Token with other user in body
+=INPUT=
+[[config]]
+=URL=/job-status
+=HEADER=Authorization: Bearer tok1
+=REQUEST={"user": "u2", "id": "42"}
+=RESPONSE=
+Attribute 'user' doesn't match token
+=STATUS=400
+
+=TITLE=Unknown token
+=INPUT=
+[[config]]
+=URL=/job-status
+=HEADER=Authorization: Bearer tok3
+=REQUEST={"id": "42"}
+=RESPONSE=
+Invalid token
+=STATUS=401
+
+=TITLE=Expired token
+=INPUT=
+[[config]]
+=URL=/job-status
+=HEADER=Authorization: Bearer expired
+=REQUEST={"id": "42"}
+=RESPONSE=
+Invalid token
+=STATUS=401
+
+=TITLE=Unsupported authorization scheme
+=INPUT=
+[[config]]
+=URL=/job-status
+=HEADER=Authorization: Digest abc
+=REQUEST={"id": "42"}
+=RESPONSE=
+Unsupported authorization scheme
+=STATUS=401
+
+=TITLE=Allowed method
+=INPUT=
+[[config]]
+=URL=/add-job
+=HEADER=Authorization: Bearer tok2
+=REQUEST=
+{"method": "multi_job",
+ "params": {"jobs": [{"method": "add"}, {"method": "delete"}]}}
+=OUTPUT=
+--waiting/1
+{"method": "multi_job",
+ "params": {"jobs": [{"method": "add"}, {"method": "delete"}]},
+ "_netspoc_api": {"user": "u1", "submitted": "2026-01-02T03:04:05Z",
+  "source": "192.0.2.1", "request_id": "42"}}
+=RESPONSE={"id": "1"}
+=STATUS=200
+
+=TITLE=Method not allowed
+=INPUT=
+[[config]]
+=URL=/add-job
+=HEADER=Authorization: Bearer tok2
+=REQUEST={"method": "set", "params": {}}
+=RESPONSE=
+Method is not allowed
+=STATUS=403
+
+=TITLE=Method not allowed in multi_job
+=INPUT=
+[[config]]
+=URL=/add-job
+=HEADER=Authorization: Bearer tok2
+=REQUEST=
+{"method": "multi_job",
+ "params": {"jobs": [{"method": "add"}, {"method": "set"}]}}
+=RESPONSE=
+Method is not allowed
+=STATUS=403
+
+=TITLE=Missing expiry
+=INPUT=
+--config
+{"user": {"u1": {"tokens": [{"hash": "80b3ad2d438bfafa1ea690c5a59f54548dcc76ad6a839c6704ac1d9d565d9c80"}]}}}
+=URL=/job-status
+=HEADER=Authorization: Bearer tok1
+=REQUEST={"id": "42"}
+=ERROR=
+Missing 'expires' in token of user 'u1'
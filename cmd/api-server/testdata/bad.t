@@ -17,7 +17,7 @@ Invalid JSON: invalid character 'N' looking for beginning of value
 =REQUEST={}
 =RESPONSE=
 Missing 'user'
-=STATUS=400
+=STATUS=401
 
 =TITLE=Missing password
 =INPUT=
@@ -27,7 +27,7 @@ Missing 'user'
 =REQUEST={"user": "u1"}
 =RESPONSE=
 Missing 'pass'
-=STATUS=400
+=STATUS=401
 
 =TITLE=Unauthorized user
 =INPUT=
@@ -36,7 +36,22 @@ Missing 'pass'
 =URL=/add-job
 =REQUEST={"user": "u1", "pass": "secret"}
 =RESPONSE=
-User is not authorized
+Authentication failed
+=STATUS=400
+
+=TITLE=Wrong password gets same answer as unknown user
+=INPUT=
+--config
+{"user": {
+  "u1": {
+    "hash": "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS"
+  }
+ }
+}
+=URL=/add-job
+=REQUEST={"user": "u1", "pass": "wrong"}
+=RESPONSE=
+Authentication failed
 =STATUS=400
 
 =TITLE=Authorized user, no authentication method configured
@@ -49,8 +64,24 @@ User is not authorized
 =URL=/add-job
 =REQUEST={"user": "u1", "pass": "secret"}
 =RESPONSE=
-No authentication method configured
-=STATUS=500
+Authentication failed
+=STATUS=400
+
+=TITLE=Disabled user is rejected before check of password
+=INPUT=
+--config
+{"user": {
+  "u1": {
+    "hash": "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS",
+    "disabled": true
+  }
+ }
+}
+=URL=/add-job
+=REQUEST={"user": "u1", "pass": "wrong"}
+=RESPONSE=
+User is disabled
+=STATUS=403
 
 =TITLE=Authorized user, bad URL
 =INPUT=
@@ -0,0 +1,96 @@
+=TEMPL=config
+--config
+{"user": {
+  "u1": {
+    "hash": "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS"
+  }
+ }
+}
+=END=
+
+# Basic dTE6c2VjcmV0 is u1:secret
+# Basic dTE6d3Jvbmc= is u1:wrong
+# Basic dTI6c2VjcmV0 is u2:secret
+
+=TITLE=Add job with basic authentication
+=INPUT=
+[[config]]
+=URL=/add-job
+=HEADER=Authorization: Basic dTE6c2VjcmV0
+=REQUEST={"method": "set", "params": {}}
+=OUTPUT=
+--waiting/1
+{"method": "set", "params": {},
+ "_netspoc_api": {"user": "u1", "submitted": "2026-01-02T03:04:05Z",
+  "source": "192.0.2.1", "request_id": "42"}}
+=RESPONSE={"id": "1"}
+=STATUS=200
+
+=TITLE=Same credentials in body and header
+=INPUT=
+[[config]]
+=URL=/job-status
+=HEADER=Authorization: Basic dTE6c2VjcmV0
+=REQUEST={"user": "u1", "pass": "secret", "id": "42"}
+=RESPONSE={"status": "UNKNOWN"}
+=STATUS=200
+
+=TITLE=Conflicting user in body
+=INPUT=
+[[config]]
+=URL=/job-status
+=HEADER=Authorization: Basic dTE6c2VjcmV0
+=REQUEST={"user": "u2", "id": "42"}
+=RESPONSE=
+Credentials in body conflict with header 'Authorization'
+=STATUS=400
+
+=TITLE=Conflicting password in body
+=INPUT=
+[[config]]
+=URL=/job-status
+=HEADER=Authorization: Basic dTE6c2VjcmV0
+=REQUEST={"user": "u1", "pass": "other", "id": "42"}
+=RESPONSE=
+Credentials in body conflict with header 'Authorization'
+=STATUS=400
+
+=TITLE=Bad password in header
+=INPUT=
+[[config]]
+=URL=/job-status
+=HEADER=Authorization: Basic dTE6d3Jvbmc=
+=REQUEST={"id": "42"}
+=RESPONSE=
+Authentication failed
+=STATUS=401
+
+=TITLE=Unknown user in header
+=INPUT=
+[[config]]
+=URL=/job-status
+=HEADER=Authorization: Basic dTI6c2VjcmV0
+=REQUEST={"id": "42"}
+=RESPONSE=
+Authentication failed
+=STATUS=401
+
+=TITLE=Invalid basic authorization
+=INPUT=
+[[config]]
+=URL=/job-status
+=HEADER=Authorization: Basic ###
+=REQUEST={"id": "42"}
+=RESPONSE=
+Invalid basic authorization
+=STATUS=401
+
+=TITLE=Empty password in header
+=INPUT=
+[[config]]
+=URL=/job-status
+=HEADER=Authorization: Basic dTE6
+=REQUEST={"id": "42"}
+=RESPONSE=
+Invalid basic authorization
+=STATUS=401
@@ -0,0 +1,73 @@
+=TEMPL=config
+--config
+{"user": {
+  "u1": {
+    "hash": "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS"
+  },
+  "u2": {
+    "hash": "$argon2id$v=19$m=64,t=1,p=1$cUAdMJKnhlWzXw8gpJEbPQ$13uZ48RBr6SQzs246JpFI6yOO3ftrosrBNwfN5Z8yvY"
+  }
+ }
+}
+=END=
+
+=TITLE=Password with bcrypt hash
+=INPUT=
+[[config]]
+=URL=/job-status
+=REQUEST={"user": "u1", "pass": "secret", "id": "42"}
+=RESPONSE={"status": "UNKNOWN"}
+=STATUS=200
+
+=TITLE=Password with argon2id hash
+=INPUT=
+[[config]]
+=URL=/job-status
+=REQUEST={"user": "u2", "pass": "secret", "id": "42"}
+=RESPONSE={"status": "UNKNOWN"}
+=STATUS=200
+
+=TITLE=Wrong password with argon2id hash
+=INPUT=
+[[config]]
+=URL=/job-status
+=REQUEST={"user": "u2", "pass": "wrong", "id": "42"}
+=RESPONSE=
+Authentication failed
+=STATUS=400
+
+=TITLE=Invalid hash
+=INPUT=
+--config
+{"user": {"u1": {"hash": "secret"}}}
+=URL=/job-status
+=REQUEST={"id": "42"}
+=ERROR=
+Invalid 'hash' of user 'u1': unknown format of hash
+
+=TITLE=Invalid argon2id hash
+=INPUT=
+--config
+{"user": {"u1": {"hash": "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5"}}}
+=URL=/job-status
+=REQUEST={"id": "42"}
+=ERROR=
+Invalid 'hash' of user 'u1': invalid parameters of argon2id hash
+
+=TITLE=Unknown algorithm in hash policy
+=INPUT=
+--config
+{"hash_policy": {"algorithm": "md5"}}
+=URL=/job-status
+=REQUEST={"id": "42"}
+=ERROR=
+Unknown 'algorithm' in 'hash_policy': md5
+
+=TITLE=Invalid bcrypt cost in hash policy
+=INPUT=
+--config
+{"hash_policy": {"bcrypt_cost": 3}}
+=URL=/job-status
+=REQUEST={"id": "42"}
+=ERROR=
+'bcrypt_cost' must be between 4 and 31
@@ -72,6 +72,28 @@ Job has invalid JSON: invalid character 'B' looking for beginning of value
 {"status": "DENIED"}
 =STATUS=200
 
+=TITLE=Finished, user from metadata
+=INPUT=
+[[config]]
+--finished/42
+{"_netspoc_api": {"user": "u1"}}
+--result/42
+=URL=/job-status
+=REQUEST={"user": "u1", "pass": "secret", "id": "42"}
+=RESPONSE={"status": "FINISHED"}
+=STATUS=200
+
+=TITLE=Denied, other user in metadata
+=INPUT=
+[[config]]
+--finished/42
+{"user": "u1", "_netspoc_api": {"user": "u2"}}
+=URL=/job-status
+=REQUEST={"user": "u1", "pass": "secret", "id": "42"}
+=RESPONSE=
+{"status": "DENIED"}
+=STATUS=200
+
 =TITLE=Finished, try again
 =INPUT=
 [[config]]
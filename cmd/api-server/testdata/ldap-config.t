@@ -0,0 +1,59 @@
+=TITLE=StartTLS with ldaps
+=INPUT=
+--config
+{"ldap": {"uri": "ldaps://ldap.example.com", "start_tls": true}}
+=ERROR=
+Must not use 'start_tls' with ldaps://
+
+=TITLE=Missing CA file
+=INPUT=
+--config
+{"ldap": {"uri": "ldap://ldap.example.com", "ca_file": "ca.pem"}}
+=ERROR=
+Can't read 'ca_file' of 'ldap': open ca.pem: no such file or directory
+
+=TITLE=Bad CA file
+=INPUT=
+--config
+{"ldap": {"uri": "ldap://ldap.example.com", "ca_file": "ca.pem"}}
+--ca.pem
+no certificate
+=ERROR=
+No certificates found in ca.pem
+
+=TITLE=Negative timeout
+=INPUT=
+--config
+{"ldap": {"uri": "ldap://ldap.example.com", "timeout": "-1s"}}
+=ERROR=
+Timeout in 'ldap' must not be negative
+
+=TITLE=Bad timeout
+=INPUT=
+--config
+{"ldap": {"uri": "ldap://ldap.example.com", "connect_timeout": "5"}}
+=ERROR=
+error while reading config: time: missing unit in duration "5"
+
+=TITLE=Unsupported scheme
+=INPUT=
+--config
+{"ldap": {"uri": "http://ldap.example.com"}}
+=ERROR=
+Unsupported scheme in 'uri' of 'ldap': http://ldap.example.com
+
+=TITLE=Missing filter placeholder
+=INPUT=
+--config
+{"ldap": {"uri": "ldap://ldap.example.com", "filter": "(uid=x)",
+          "base_dn": "dc=example"}}
+=ERROR=
+Missing '%s' in 'filter' of 'ldap'
+
+=TITLE=Groups without URI
+=INPUT=
+--config
+{"ldap": {"groups": {"cn=g,dc=example": ["admin"]},
+          "group_base_dn": "dc=example"}}
+=ERROR=
+Missing 'uri' for 'groups' in 'ldap'
@@ -0,0 +1,88 @@
+=TEMPL=config
+--config
+{"identity_header": "X-Remote-User",
+ "trusted_proxies": ["127.0.0.1", "10.1.1.0/24"],
+ "user": {
+  "u1": {
+    "hash": "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS"
+  }
+ }
+}
+=END=
+
+=TITLE=Add job with identity from trusted proxy
+=INPUT=
+[[config]]
+=URL=/add-job
+=REMOTE_ADDR=10.1.1.7
+=HEADER=X-Remote-User: u1
+=REQUEST={"method": "set", "params": {}}
+=OUTPUT=
+--waiting/1
+{"method": "set", "params": {},
+ "_netspoc_api": {"user": "u1", "submitted": "2026-01-02T03:04:05Z",
+  "source": "10.1.1.7", "request_id": "42"}}
+=RESPONSE={"id": "1"}
+=STATUS=200
+
+=TITLE=Identity header from IPv6 loopback is rejected
+=INPUT=
+[[config]]
+=URL=/job-status
+=REMOTE_ADDR=[::1]
+=HEADER=X-Remote-User: u1
+=REQUEST={"id": "42"}
+=RESPONSE=
+Header 'X-Remote-User' not allowed from ::1
+=STATUS=403
+
+=TITLE=Identity header from other address is rejected
+=INPUT=
+[[config]]
+=URL=/job-status
+=REMOTE_ADDR=10.1.2.7
+=HEADER=X-Remote-User: u1
+=REQUEST={"user": "u1", "pass": "secret", "id": "42"}
+=RESPONSE=
+Header 'X-Remote-User' not allowed from 10.1.2.7
+=STATUS=403
+
+=TITLE=Password is still accepted from other address
+=INPUT=
+[[config]]
+=URL=/job-status
+=REMOTE_ADDR=10.1.2.7
+=REQUEST={"user": "u1", "pass": "secret", "id": "42"}
+=RESPONSE={"status": "UNKNOWN"}
+=STATUS=200
+
+=TITLE=Unknown user from trusted proxy
+=INPUT=
+[[config]]
+=URL=/job-status
+=REMOTE_ADDR=127.0.0.1
+=HEADER=X-Remote-User: u2
+=REQUEST={"id": "42"}
+=RESPONSE=
+User is not authorized
+=STATUS=403
+
+=TITLE=Conflicting user in body
+=INPUT=
+[[config]]
+=URL=/job-status
+=REMOTE_ADDR=127.0.0.1
+=HEADER=X-Remote-User: u1
+=REQUEST={"user": "u2", "id": "42"}
+=RESPONSE=
+Attribute 'user' doesn't match header 'X-Remote-User'
+=STATUS=400
+
+=TITLE=Identity header without trusted proxies
+=INPUT=
+--config
+{"identity_header": "X-Remote-User"}
+=URL=/job-status
+=REQUEST={"id": "42"}
+=ERROR=
+Missing 'trusted_proxies' for 'identity_header'
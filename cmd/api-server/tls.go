@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Files for native TLS listener.
+// Listener is only used, if environment variable LISTENTLS is set.
+type tlsConfig struct {
+	Cert string
+	Key  string
+	// Optional bundle of CA certificates to verify client certificates.
+	ClientCA string `json:"client_ca"`
+}
+
+// Certificates currently used by TLS listener.
+// Are read again on SIGHUP.
+type serverTLS struct {
+	mutex     sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func newServerTLS() (*serverTLS, error) {
+	s := new(serverTLS)
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Read certificate, key and CA bundle from files given in config.
+// Old values are kept, if some error occurs.
+func (s *serverTLS) load() error {
+	cert, pool, err := current.Load().TLS.loadFiles()
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.cert = cert
+	s.clientCAs = pool
+	s.mutex.Unlock()
+	return nil
+}
+
+// Read and check files given in config.
+func (c *tlsConfig) loadFiles() (*tls.Certificate, *x509.CertPool, error) {
+	if c == nil || c.Cert == "" || c.Key == "" {
+		return nil, nil,
+			fmt.Errorf("Missing 'cert' or 'key' in 'tls' of config")
+	}
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Can't load TLS certificate: %v", err)
+	}
+	var pool *x509.CertPool
+	if c.ClientCA != "" {
+		data, err := os.ReadFile(c.ClientCA)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Can't read client CA: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, nil,
+				fmt.Errorf("No certificates found in %s", c.ClientCA)
+		}
+	}
+	return &cert, pool, nil
+}
+
+// Configuration of TLS listener.
+// Current certificates are used for each new connection.
+func (s *serverTLS) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			c := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*s.cert},
+			}
+			if s.clientCAs != nil {
+				c.ClientCAs = s.clientCAs
+				c.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return c, nil
+		},
+	}
+}
+
+// Find name of user from verified client certificate.
+// Subject CN and subject alternative names are compared
+// with names of configured users.
+func (conf *config) certUser(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	for _, name := range names {
+		if _, found := conf.User[name]; found && name != "" {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var serial int64
+
+// Create certificate signed by parent or self signed if parent is nil.
+func newCert(t *testing.T, tmpl *x509.Certificate,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+) (*x509.Certificate, *ecdsa.PrivateKey) {
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	serial++
+	tmpl.SerialNumber = big.NewInt(serial)
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func writePEM(t *testing.T, file string, cert *x509.Certificate,
+	key *ecdsa.PrivateKey) {
+
+	data := pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if key != nil {
+		der, _ := x509.MarshalECPrivateKey(key)
+		data = append(data, pem.EncodeToMemory(
+			&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})...)
+	}
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientCert(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := newCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	srvCert, srvKey := newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	writePEM(t, dir+"/ca.pem", ca, nil)
+	writePEM(t, dir+"/server.pem", srvCert, srvKey)
+
+	client := func(tmpl *x509.Certificate) tls.Certificate {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		cert, key := newCert(t, tmpl, ca, caKey)
+		return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}
+	}
+	byCN := client(&x509.Certificate{Subject: pkix.Name{CommonName: "u1"}})
+	bySAN := client(&x509.Certificate{
+		Subject:  pkix.Name{CommonName: "automation"},
+		DNSNames: []string{"host.example.com"},
+	})
+	unknown := client(&x509.Certificate{Subject: pkix.Name{CommonName: "u9"}})
+	// Self signed, not issued by CA.
+	other, otherKey := newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "u1"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, nil, nil)
+	untrusted := tls.Certificate{
+		Certificate: [][]byte{other.Raw}, PrivateKey: otherKey}
+
+	setupConfig(t, `
+{"tls": {
+  "cert": "`+dir+`/server.pem",
+  "key": "`+dir+`/server.pem",
+  "client_ca": "`+dir+`/ca.pem"
+ },
+ "user": {"u1": {}, "host.example.com": {}}
+}`)
+	srvTLS, err := newServerTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(handleRequest))
+	srv.TLS = srvTLS.config()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	post := func(cert *tls.Certificate, body string) string {
+		c := tls.Config{RootCAs: roots}
+		if cert != nil {
+			c.Certificates = []tls.Certificate{*cert}
+		}
+		client := http.Client{Transport: &http.Transport{TLSClientConfig: &c}}
+		resp, err := client.Post(srv.URL+"/job-status", "application/json",
+			strings.NewReader(body))
+		if err != nil {
+			return err.Error()
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return string(data)
+	}
+	ok := `{"status":"UNKNOWN"}` + "\n"
+	eq(t, ok, post(&byCN, `{"id": "42"}`))
+	eq(t, ok, post(&bySAN, `{"id": "42"}`))
+	eq(t, ok, post(&byCN, `{"user": "u1", "id": "42"}`))
+	eq(t, "Attribute 'user' doesn't match client certificate\n",
+		post(&byCN, `{"user": "host.example.com", "id": "42"}`))
+	eq(t, "Missing 'user'\n", post(&unknown, `{"id": "42"}`))
+	eq(t, "Missing 'user'\n", post(nil, `{"id": "42"}`))
+	if got := post(&untrusted, `{"id": "42"}`); got == ok {
+		t.Error("Must not accept certificate from unknown CA")
+	}
+
+	// Keep old certificates if reload fails.
+	os.Remove(dir + "/ca.pem")
+	if err := srvTLS.load(); err == nil {
+		t.Error("Expected error on reload")
+	}
+	eq(t, ok, post(&byCN, `{"id": "42"}`))
+}
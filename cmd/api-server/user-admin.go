@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hknutzen/Netspoc-API/internal/pwhash"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role required for management of users.
+const adminRole = "admin"
+
+// Body of requests for management of users.
+// Attributes "user" and "pass" are used for authentication
+// of requesting client.
+type userArgs struct {
+	Name            string
+	Password        string
+	OldPassword     string `json:"old_password"`
+	LDAP            bool
+	Roles           *[]string
+	AllowedNetworks *[]string `json:"allowed_networks"`
+	Methods         *[]string
+}
+
+// Invalid request, detected while changing config.
+type requestError string
+
+func (e requestError) Error() string { return string(e) }
+
+func (conf *config) hasRole(auth *identity, role string) bool {
+	if slices.Contains(auth.roles, role) {
+		return true
+	}
+	u := conf.User[auth.user]
+	return u != nil && slices.Contains(u.Roles, role)
+}
+
+// Create hash of new password according to hash policy.
+func (conf *config) hashPassword(pass string) (string, error) {
+	if p := conf.HashPolicy; p != nil {
+		return p.policy.Hash([]byte(pass))
+	}
+	return pwhash.Bcrypt([]byte(pass), bcrypt.DefaultCost)
+}
+
+// Handle requests /add-user, /delete-user, /disable-user,
+// /enable-user, /reset-password and /set-user.
+func (conf *config) manageUser(w http.ResponseWriter, r *http.Request, body []byte,
+	auth *identity) {
+
+	if !conf.hasRole(auth, adminRole) {
+		forbidden(w, "Role 'admin' is required")
+		return
+	}
+	action := strings.TrimPrefix(r.URL.Path, "/")
+	var args userArgs
+	json.Unmarshal(body, &args)
+	name := args.Name
+	if name == "" {
+		badRequest(w, "Missing 'name'")
+		return
+	}
+	if name == auth.user &&
+		(action == "delete-user" || action == "disable-user") {
+		badRequest(w, "Must not "+strings.Replace(action, "-", " own ", 1))
+		return
+	}
+	var hash string
+	if action == "add-user" && !args.LDAP || action == "reset-password" {
+		if args.Password == "" {
+			badRequest(w, "Missing 'password'")
+			return
+		}
+		var err error
+		if hash, err = conf.hashPassword(args.Password); err != nil {
+			internalErr(w, err.Error())
+			return
+		}
+	}
+	if args.AllowedNetworks != nil {
+		if _, err := parsePrefixes(*args.AllowedNetworks); err != nil {
+			badRequest(w, "Invalid 'allowed_networks': "+err.Error())
+			return
+		}
+	}
+	err := changeUser(name, func(u *jsonObject, found bool) error {
+		if action == "add-user" {
+			if found {
+				return requestError("User '" + name + "' already exists")
+			}
+			if hash != "" {
+				u.set("hash", hash)
+			} else {
+				u.set("ldap", true)
+			}
+		} else if !found {
+			return requestError("Unknown user '" + name + "'")
+		}
+		switch action {
+		case "delete-user":
+			return errDelete
+		case "disable-user":
+			u.set("disabled", true)
+		case "enable-user":
+			u.remove("disabled")
+		case "reset-password":
+			u.set("hash", hash)
+		}
+		if action == "add-user" || action == "set-user" {
+			setList(u, "roles", args.Roles)
+			setList(u, "allowed_networks", args.AllowedNetworks)
+			setList(u, "methods", args.Methods)
+		}
+		return nil
+	})
+	if !answerChange(w, err) {
+		return
+	}
+	conf.audit(r, auth, action, name)
+	json.NewEncoder(w).Encode(jsonMap{})
+}
+
+// Change own password of authenticated user.
+func (conf *config) changePassword(w http.ResponseWriter, r *http.Request, body []byte,
+	auth *identity) {
+
+	start := time.Now()
+	var args userArgs
+	json.Unmarshal(body, &args)
+	u := conf.User[auth.user]
+	if u == nil || u.Hash == "" {
+		badRequest(w, "Password of user can't be changed")
+		return
+	}
+	if args.OldPassword == "" || args.Password == "" {
+		badRequest(w, "Missing 'old_password' or 'password'")
+		return
+	}
+	if ok, _ := pwhash.Verify(u.Hash, []byte(args.OldPassword)); !ok {
+		conf.authFailed(w, auth.user, conf.clientAddr(r).String(), start,
+			badRequest,
+			"Authentication failed")
+		return
+	}
+	hash, err := conf.hashPassword(args.Password)
+	if err != nil {
+		internalErr(w, err.Error())
+		return
+	}
+	err = changeUser(auth.user, func(u *jsonObject, found bool) error {
+		if !found {
+			return requestError("Password of user can't be changed")
+		}
+		u.set("hash", hash)
+		return nil
+	})
+	if !answerChange(w, err) {
+		return
+	}
+	conf.audit(r, auth, "change-password", auth.user)
+	json.NewEncoder(w).Encode(jsonMap{})
+}
+
+// Returned by edit function of changeUser to delete user.
+var errDelete = errors.New("delete user")
+
+// Change attributes of user in config file and load changed config.
+func changeUser(name string, edit func(*jsonObject, bool) error) error {
+	err := updateConfigFile(func(data []byte) ([]byte, error) {
+		var top jsonObject
+		if err := json.Unmarshal(data, &top); err != nil {
+			return nil, err
+		}
+		users, err := top.object("user")
+		if err != nil {
+			return nil, err
+		}
+		users.exact = true
+		found := users.has(name)
+		u, err := users.object(name)
+		if err != nil {
+			return nil, err
+		}
+		switch err := edit(u, found); err {
+		case nil:
+			users.set(name, u)
+		case errDelete:
+			users.remove(name)
+		default:
+			return nil, err
+		}
+		top.set("user", users)
+		data, _ = json.MarshalIndent(&top, "", " ")
+		data = append(data, '\n')
+		// Config file must not be changed to invalid content.
+		c, err := parseConfig(confFile, data)
+		if err != nil {
+			return nil, requestError("Invalid changed config: " + err.Error())
+		}
+		c.close()
+		return data, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := loadConfig(); err != nil {
+		return fmt.Errorf("Can't load changed config: %v", err)
+	}
+	return nil
+}
+
+// Set or remove list valued attribute of user.
+func setList(u *jsonObject, k string, l *[]string) {
+	switch {
+	case l == nil:
+	case len(*l) == 0:
+		u.remove(k)
+	default:
+		u.set(k, *l)
+	}
+}
+
+// Send error of changeUser. Returns true if no error occurred.
+func answerChange(w http.ResponseWriter, err error) bool {
+	var reqErr requestError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &reqErr):
+		badRequest(w, err.Error())
+	default:
+		internalErr(w, "Can't change config: "+err.Error())
+	}
+	return false
+}
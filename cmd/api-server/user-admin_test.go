@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUserAdmin(t *testing.T) {
+	setupConfig(t, `{
+ "hash_policy": {"bcrypt_cost": 4},
+ `+adminUsers+`
+}`)
+	check := func(url, header, body string, code int, expected string) {
+		t.Helper()
+		checkResponse(t, url, header, body, code, expected)
+	}
+	ok := func(url, body string) {
+		t.Helper()
+		check(url, adminAuth, body, http.StatusOK, "{}")
+	}
+	status := func(user, pass string, code int) {
+		t.Helper()
+		check("/job-status", "",
+			`{"user": "`+user+`", "pass": "`+pass+`", "id": "1"}`, code, "")
+	}
+
+	check("/add-user", "", `{"user": "u1", "pass": "secret", "name": "u2"}`,
+		http.StatusForbidden, "Role 'admin' is required")
+	check("/add-user", adminAuth, `{"password": "pw2"}`,
+		http.StatusBadRequest, "Missing 'name'")
+	check("/add-user", adminAuth, `{"name": "u2"}`,
+		http.StatusBadRequest, "Missing 'password'")
+	check("/add-user", adminAuth, `{"name": "u1", "password": "x"}`,
+		http.StatusBadRequest, "User 'u1' already exists")
+	check("/set-user", adminAuth, `{"name": "u9"}`,
+		http.StatusBadRequest, "Unknown user 'u9'")
+	check("/delete-user", adminAuth, `{"name": "admin"}`,
+		http.StatusBadRequest, "Must not delete own user")
+	check("/set-user", adminAuth, `{"name": "u1", "allowed_networks": ["x"]}`,
+		http.StatusBadRequest,
+		`Invalid 'allowed_networks': ParseAddr("x"): unable to parse IP`)
+
+	ok("/add-user", `{"name": "u2", "password": "pw2",
+ "methods": ["add"], "allowed_networks": ["192.0.2.0/24"]}`)
+	status("u2", "pw2", http.StatusOK)
+	check("/add-job", "", `{"user": "u2", "pass": "pw2", "method": "delete"}`,
+		http.StatusForbidden, "Method is not allowed")
+	ok("/set-user", `{"name": "u2", "allowed_networks": ["10.1.1.0/24"]}`)
+	status("u2", "pw2", http.StatusForbidden)
+	ok("/set-user", `{"name": "u2", "allowed_networks": []}`)
+	status("u2", "pw2", http.StatusOK)
+
+	ok("/disable-user", `{"name": "u2"}`)
+	status("u2", "pw2", http.StatusForbidden)
+	ok("/enable-user", `{"name": "u2"}`)
+	status("u2", "pw2", http.StatusOK)
+
+	ok("/reset-password", `{"name": "u2", "password": "new"}`)
+	status("u2", "pw2", http.StatusBadRequest)
+	status("u2", "new", http.StatusOK)
+
+	check("/change-password", "",
+		`{"user": "u2", "pass": "new", "old_password": "bad", "password": "x"}`,
+		http.StatusBadRequest, "Authentication failed")
+	check("/change-password", "",
+		`{"user": "u2", "pass": "new", "old_password": "new", "password": "own"}`,
+		http.StatusOK, "{}")
+	status("u2", "own", http.StatusOK)
+
+	ok("/delete-user", `{"name": "u2"}`)
+	status("u2", "own", http.StatusBadRequest)
+
+	// Invalid config isn't written.
+	check("/add-user", adminAuth, `{"name": "x", "ldap": true}`,
+		http.StatusBadRequest,
+		"Invalid changed config: No 'ldap_uri' has been configured")
+
+	// Other attributes and their order are kept.
+	data, _ := os.ReadFile("config")
+	var top jsonObject
+	if err := json.Unmarshal(data, &top); err != nil {
+		t.Fatal(err)
+	}
+	eq(t, "hash_policy user", strings.Join(top.keys, " "))
+	users, _ := top.object("user")
+	eq(t, "admin u1", strings.Join(users.keys, " "))
+	var p hashPolicy
+	json.Unmarshal(top.values["hash_policy"], &p)
+	eq(t, "4", strconv.Itoa(p.BcryptCost))
+	if _, err := os.Stat("config.bak"); err != nil {
+		t.Error(err)
+	}
+
+	data, _ = os.ReadFile(auditFile)
+	var actions []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e auditEntry
+		json.Unmarshal([]byte(line), &e)
+		actions = append(actions, e.By+":"+e.Action+":"+e.User)
+	}
+	eq(t, strings.Join([]string{
+		"admin:add-user:u2",
+		"admin:set-user:u2",
+		"admin:set-user:u2",
+		"admin:disable-user:u2",
+		"admin:enable-user:u2",
+		"admin:reset-password:u2",
+		"u2:change-password:u2",
+		"admin:delete-user:u2",
+	}, "\n"), strings.Join(actions, "\n"))
+	if strings.Contains(string(data), "own") {
+		t.Error("Audit log must not contain password")
+	}
+}
+
+// Attributes are matched case-insensitively like in encoding/json.
+func TestUserAdminKeyCase(t *testing.T) {
+	setupConfig(t, `{
+ "User": {
+  "admin": {
+   "hash": "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS",
+   "roles": ["admin"]
+  },
+  "u1": {
+   "Hash": "$2a$04$Y9E/EE0BJd4ABTLgRTR0I.bgjmQAYuj9jXVHUL1t9fewKk8IATVWS",
+   "Disabled": true
+  }
+ }
+}`)
+	status := func(pass string) int {
+		return call("/job-status", "",
+			`{"user": "u1", "pass": "`+pass+`", "id": "1"}`).Code
+	}
+	if c := status("secret"); c != http.StatusForbidden {
+		t.Errorf("Disabled user: want status 403, got %d", c)
+	}
+	ok := func(url, body string) {
+		t.Helper()
+		if resp := call(url, adminAuth, body); resp.Code != http.StatusOK {
+			t.Fatalf("%s: %s", url, resp.Body)
+		}
+	}
+	ok("/enable-user", `{"name": "u1"}`)
+	ok("/reset-password", `{"name": "u1", "password": "new"}`)
+	if c := status("new"); c != http.StatusOK {
+		t.Errorf("Enabled user: want status 200, got %d", c)
+	}
+	data, _ := os.ReadFile("config")
+	var top jsonObject
+	json.Unmarshal(data, &top)
+	eq(t, "User", strings.Join(top.keys, " "))
+	users, _ := top.object("user")
+	u1, _ := users.object("u1")
+	eq(t, "Hash", strings.Join(u1.keys, " "))
+}
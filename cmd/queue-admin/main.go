@@ -0,0 +1,428 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hknutzen/Netspoc-API/internal/queue"
+)
+
+// Inspect and maintain job queue of api-server.
+// Jobs are read from directories of job queue or from SQLite database.
+func main() {
+	dir := flag.String("dir", os.Getenv("HOME"),
+		"Directory with job queue")
+	db := flag.String("sqlite", "", "Read jobs from SQLite database `file`")
+	asJSON := flag.Bool("json", false, "Print output as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			`Usage: %s [options] command [arguments]
+Commands:
+  list [-state STATE] [-user NAME] [-crq CRQ]
+  show ID
+  requeue [-older DURATION] [ID ...]
+  purge [-older DURATION] [-archive FILE]
+  stats [-since DURATION]
+Options:
+`, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var s queue.Store = queue.NewDirStore(*dir)
+	if *db != "" {
+		var err error
+		s, err = queue.OpenSQLStore(*db)
+		if err != nil {
+			abort(err)
+		}
+	}
+	defer s.Close()
+	a := &admin{store: s, out: os.Stdout, json: *asJSON}
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	commands := map[string]func([]string) error{
+		"list":    a.list,
+		"show":    a.show,
+		"requeue": a.requeue,
+		"purge":   a.purge,
+		"stats":   a.stats,
+	}
+	f := commands[cmd]
+	if f == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := f(args); err != nil {
+		s.Close()
+		abort(err)
+	}
+}
+
+func abort(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
+
+// Is replaced in tests.
+var timeNow = time.Now
+
+type admin struct {
+	store queue.Store
+	out   io.Writer
+	json  bool
+}
+
+// Parse flags of command. Some commands take no arguments.
+func parse(fs *flag.FlagSet, args []string, noArgs bool) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if noArgs && fs.NArg() != 0 {
+		return fmt.Errorf("Unexpected argument %q", fs.Arg(0))
+	}
+	return nil
+}
+
+// Summary of job as printed by list and show.
+type jobEntry struct {
+	ID        string      `json:"id"`
+	State     queue.State `json:"state"`
+	Changed   time.Time   `json:"changed"`
+	User      string      `json:"user"`
+	CRQ       string      `json:"crq,omitempty"`
+	Method    string      `json:"method,omitempty"`
+	Submitted string      `json:"submitted,omitempty"`
+	Source    string      `json:"source,omitempty"`
+}
+
+func entry(j *queue.Job) jobEntry {
+	// Job with invalid JSON is shown without attributes.
+	info, _ := j.Info()
+	return jobEntry{
+		ID:        j.ID,
+		State:     j.State,
+		Changed:   j.Changed.UTC().Truncate(time.Second),
+		User:      info.User,
+		CRQ:       info.CRQ,
+		Method:    info.Method,
+		Submitted: info.Meta.Submitted,
+		Source:    info.Meta.Source,
+	}
+}
+
+func (a *admin) print(v any, text func(w io.Writer)) error {
+	if a.json {
+		enc := json.NewEncoder(a.out)
+		enc.SetIndent("", " ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(a.out, 0, 8, 2, ' ', 0)
+	text(tw)
+	return tw.Flush()
+}
+
+func (a *admin) list(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	state := fs.String("state", "", "Only jobs in `STATE`")
+	user := fs.String("user", "", "Only jobs of user")
+	crq := fs.String("crq", "", "Only jobs of change request")
+	if err := parse(fs, args, true); err != nil {
+		return err
+	}
+	f := queue.Filter{
+		State: queue.State(strings.ToUpper(*state)),
+		User:  *user,
+		CRQ:   *crq,
+	}
+	switch f.State {
+	case "", queue.Waiting, queue.InProgress, queue.Finished:
+	default:
+		return fmt.Errorf("Unknown state %q", *state)
+	}
+	jobs, err := a.store.List(f)
+	if err != nil {
+		return err
+	}
+	l := make([]jobEntry, 0, len(jobs))
+	for _, j := range jobs {
+		l = append(l, entry(j))
+	}
+	return a.print(l, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tSTATE\tCHANGED\tUSER\tCRQ\tMETHOD")
+		for _, e := range l {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.ID, e.State,
+				e.Changed.Format(time.RFC3339), e.User, e.CRQ, e.Method)
+		}
+	})
+}
+
+func (a *admin) show(args []string) error {
+	fs := flag.NewFlagSet("show", flag.ContinueOnError)
+	if err := parse(fs, args, false); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("Expected ID of job")
+	}
+	j, err := a.store.Get(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var result *string
+	if j.State == queue.Finished {
+		data, err := a.store.Result(j.ID)
+		if err != nil {
+			return err
+		}
+		s := string(data)
+		result = &s
+	}
+	v := struct {
+		jobEntry
+		Job    json.RawMessage `json:"job"`
+		Result *string         `json:"result,omitempty"`
+	}{entry(j), rawJob(j.Data), result}
+	return a.print(v, func(w io.Writer) {
+		fmt.Fprintf(w, "ID:\t%s\n", j.ID)
+		fmt.Fprintf(w, "State:\t%s\n", j.State)
+		fmt.Fprintf(w, "Changed:\t%s\n", v.Changed.Format(time.RFC3339))
+		fmt.Fprintf(w, "Job:\t%s\n", strings.TrimSuffix(string(j.Data), "\n"))
+		if result != nil {
+			if *result == "" {
+				fmt.Fprintf(w, "Result:\tsuccess\n")
+			} else {
+				fmt.Fprintf(w, "Result:\t%s\n", strings.TrimSuffix(*result, "\n"))
+			}
+		}
+	})
+}
+
+// Invalid job is shown as string.
+func rawJob(data []byte) json.RawMessage {
+	if json.Valid(data) {
+		return data
+	}
+	s, _ := json.Marshal(string(data))
+	return s
+}
+
+// Move jobs from INPROGRESS back to WAITING. Without arguments, all
+// jobs are moved, that are in progress for longer than given duration.
+func (a *admin) requeue(args []string) error {
+	fs := flag.NewFlagSet("requeue", flag.ContinueOnError)
+	older := fs.Duration("older", time.Hour,
+		"Only jobs in progress for longer than this")
+	if err := parse(fs, args, false); err != nil {
+		return err
+	}
+	jobs, err := a.store.List(queue.Filter{State: queue.InProgress})
+	if err != nil {
+		return err
+	}
+	ids := fs.Args()
+	limit := timeNow().Add(-*older)
+	var done []string
+	var errs []error
+	for _, j := range jobs {
+		if ids != nil && !slices.Contains(ids, j.ID) ||
+			ids == nil && j.Changed.After(limit) {
+			continue
+		}
+		if err := a.store.Move(j.ID, queue.InProgress, queue.Waiting, nil); err != nil {
+			errs = append(errs, fmt.Errorf("Job %s: %v", j.ID, err))
+			continue
+		}
+		done = append(done, j.ID)
+	}
+	for _, id := range ids {
+		if !slices.Contains(done, id) && !slices.ContainsFunc(
+			jobs, func(j *queue.Job) bool { return j.ID == id }) {
+			errs = append(errs, fmt.Errorf("Job %s isn't in progress", id))
+		}
+	}
+	err = a.printIDs("Requeued", done)
+	return errors.Join(append([]error{err}, errs...)...)
+}
+
+func (a *admin) printIDs(action string, ids []string) error {
+	if ids == nil {
+		ids = []string{}
+	}
+	return a.print(ids, func(w io.Writer) {
+		for _, id := range ids {
+			fmt.Fprintln(w, action, id)
+		}
+	})
+}
+
+// Remove finished jobs. Optionally, jobs are appended to archive
+// file as JSON lines before they are removed.
+func (a *admin) purge(args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	older := fs.Duration("older", 7*24*time.Hour,
+		"Only jobs finished longer ago than this")
+	archive := fs.String("archive", "",
+		"Append removed jobs to `file`")
+	if err := parse(fs, args, true); err != nil {
+		return err
+	}
+	jobs, err := a.store.List(queue.Filter{State: queue.Finished})
+	if err != nil {
+		return err
+	}
+	var arch *os.File
+	if *archive != "" {
+		arch, err = os.OpenFile(*archive,
+			os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		defer arch.Close()
+	}
+	limit := timeNow().Add(-*older)
+	var done []string
+	for _, j := range jobs {
+		if j.Changed.After(limit) {
+			continue
+		}
+		if arch != nil {
+			result, err := a.store.Result(j.ID)
+			if err != nil && !errors.Is(err, os.ErrNotExist) &&
+				err != queue.ErrNotFound {
+				return err
+			}
+			line, _ := json.Marshal(struct {
+				ID       string          `json:"id"`
+				Finished time.Time       `json:"finished"`
+				Job      json.RawMessage `json:"job"`
+				Result   string          `json:"result"`
+			}{ID: j.ID, Finished: j.Changed.UTC(),
+				Job: rawJob(j.Data), Result: string(result)})
+			if _, err := arch.Write(append(line, '\n')); err != nil {
+				return err
+			}
+			if err := arch.Sync(); err != nil {
+				return err
+			}
+		}
+		if err := a.store.Delete(j.ID); err != nil {
+			return err
+		}
+		done = append(done, j.ID)
+	}
+	return a.printIDs("Removed", done)
+}
+
+type summary struct {
+	Since      time.Time      `json:"since"`
+	Waiting    int            `json:"waiting"`
+	InProgress int            `json:"inprogress"`
+	Finished   int            `json:"finished"`
+	Errors     int            `json:"errors"`
+	PerHour    float64        `json:"per_hour"`
+	ErrorRate  float64        `json:"error_rate"`
+	OldestWait string         `json:"oldest_waiting,omitempty"`
+	ByUser     map[string]int `json:"by_user"`
+	// Number of errors by first line of error message.
+	ByError map[string]int `json:"by_error"`
+}
+
+// Print number of jobs by state and throughput and errors of jobs
+// finished in given time.
+func (a *admin) stats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	since := fs.Duration("since", 24*time.Hour,
+		"Count jobs finished in this time")
+	if err := parse(fs, args, true); err != nil {
+		return err
+	}
+	jobs, err := a.store.List(queue.Filter{})
+	if err != nil {
+		return err
+	}
+	now := timeNow()
+	s := summary{
+		Since:   now.Add(-*since).UTC().Truncate(time.Second),
+		ByUser:  make(map[string]int),
+		ByError: make(map[string]int),
+	}
+	var oldest time.Time
+	for _, j := range jobs {
+		switch j.State {
+		case queue.Waiting:
+			s.Waiting++
+			if oldest.IsZero() || j.Changed.Before(oldest) {
+				oldest = j.Changed
+			}
+		case queue.InProgress:
+			s.InProgress++
+		case queue.Finished:
+			if j.Changed.Before(s.Since) {
+				continue
+			}
+			s.Finished++
+			if info, _ := j.Info(); info.User != "" {
+				s.ByUser[info.User]++
+			}
+			result, err := a.store.Result(j.ID)
+			if err != nil {
+				s.Errors++
+				s.ByError["Missing result"]++
+			} else if len(result) != 0 {
+				s.Errors++
+				msg, _, _ := strings.Cut(string(result), "\n")
+				s.ByError[msg]++
+			}
+		}
+	}
+	if h := since.Hours(); h > 0 {
+		s.PerHour = float64(s.Finished) / h
+	}
+	if s.Finished > 0 {
+		s.ErrorRate = float64(s.Errors) / float64(s.Finished)
+	}
+	if !oldest.IsZero() {
+		s.OldestWait = now.Sub(oldest).Truncate(time.Second).String()
+	}
+	return a.print(s, func(w io.Writer) {
+		fmt.Fprintf(w, "Waiting:\t%d\n", s.Waiting)
+		fmt.Fprintf(w, "In progress:\t%d\n", s.InProgress)
+		if s.OldestWait != "" {
+			fmt.Fprintf(w, "Oldest waiting:\t%s\n", s.OldestWait)
+		}
+		fmt.Fprintf(w, "Since:\t%s\n", s.Since.Format(time.RFC3339))
+		fmt.Fprintf(w, "Finished:\t%d\n", s.Finished)
+		fmt.Fprintf(w, "Jobs per hour:\t%.1f\n", s.PerHour)
+		fmt.Fprintf(w, "Errors:\t%d (%.1f%%)\n", s.Errors, 100*s.ErrorRate)
+		for _, u := range sortedByCount(s.ByUser) {
+			fmt.Fprintf(w, "  User %s:\t%d\n", u, s.ByUser[u])
+		}
+		for _, m := range sortedByCount(s.ByError) {
+			fmt.Fprintf(w, "  %d x\t%s\n", s.ByError[m], m)
+		}
+	})
+}
+
+func sortedByCount(m map[string]int) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(m[b]-m[a], strings.Compare(a, b))
+	})
+	return keys
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hknutzen/Netspoc-API/internal/queue"
+)
+
+// Create queue with given files in temporary directory.
+func setupQueue(t *testing.T, files map[string]string) *admin {
+	dir := t.TempDir()
+	for _, d := range []string{"waiting", "inprogress", "finished", "result"} {
+		os.Mkdir(filepath.Join(dir, d), 0755)
+	}
+	for name, data := range files {
+		os.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+	}
+	return &admin{store: queue.NewDirStore(dir), out: new(strings.Builder)}
+}
+
+// Let current time be given duration in the future.
+func later(t *testing.T, d time.Duration) {
+	orig := timeNow
+	t.Cleanup(func() { timeNow = orig })
+	timeNow = func() time.Time { return orig().Add(d) }
+}
+
+// IDs of jobs by state.
+func states(t *testing.T, a *admin) string {
+	t.Helper()
+	jobs, err := a.store.List(queue.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l []string
+	for _, j := range jobs {
+		l = append(l, j.ID+":"+string(j.State))
+	}
+	return strings.Join(l, " ")
+}
+
+func output(a *admin) string {
+	return a.out.(*strings.Builder).String()
+}
+
+func eq(t *testing.T, expected, got string) {
+	t.Helper()
+	if d := cmp.Diff(expected, got); d != "" {
+		t.Error(d)
+	}
+}
+
+func errText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func TestRequeue(t *testing.T) {
+	for _, c := range []struct {
+		title string
+		age   time.Duration
+		args  []string
+		out   string
+		err   string
+		jobs  string
+	}{
+		{
+			title: "Recent jobs are kept",
+			jobs:  "1:INPROGRESS 2:INPROGRESS 3:WAITING",
+		},
+		{
+			title: "Stale jobs",
+			age:   2 * time.Hour,
+			out:   "Requeued 1\nRequeued 2\n",
+			jobs:  "1:WAITING 2:WAITING 3:WAITING",
+		},
+		{
+			title: "Limit of age",
+			age:   2 * time.Hour,
+			args:  []string{"-older", "3h"},
+			jobs:  "1:INPROGRESS 2:INPROGRESS 3:WAITING",
+		},
+		{
+			title: "Given ID regardless of age",
+			args:  []string{"2"},
+			out:   "Requeued 2\n",
+			jobs:  "1:INPROGRESS 2:WAITING 3:WAITING",
+		},
+		{
+			title: "ID not in progress",
+			args:  []string{"2", "3", "9"},
+			out:   "Requeued 2\n",
+			err:   "Job 3 isn't in progress\nJob 9 isn't in progress",
+			jobs:  "1:INPROGRESS 2:WAITING 3:WAITING",
+		},
+	} {
+		t.Run(c.title, func(t *testing.T) {
+			a := setupQueue(t, map[string]string{
+				"inprogress/1": "{}", "inprogress/2": "{}", "waiting/3": "{}",
+			})
+			later(t, c.age)
+			eq(t, c.err, errText(a.requeue(c.args)))
+			eq(t, c.out, output(a))
+			eq(t, c.jobs, states(t, a))
+		})
+	}
+}
+
+func TestPurge(t *testing.T) {
+	for _, c := range []struct {
+		title   string
+		age     time.Duration
+		archive bool
+		out     string
+		jobs    string
+	}{
+		{
+			title: "Recent jobs are kept",
+			jobs:  "1:FINISHED 2:FINISHED 3:WAITING",
+		},
+		{
+			title: "Old jobs",
+			age:   8 * 24 * time.Hour,
+			out:   "Removed 1\nRemoved 2\n",
+			jobs:  "3:WAITING",
+		},
+		{
+			title:   "Archive",
+			age:     8 * 24 * time.Hour,
+			archive: true,
+			out:     "Removed 1\nRemoved 2\n",
+			jobs:    "3:WAITING",
+		},
+	} {
+		t.Run(c.title, func(t *testing.T) {
+			a := setupQueue(t, map[string]string{
+				"finished/1": `{"crq":"c1"}`, "result/1": "Error: x\n",
+				"finished/2": "bad", "result/2": "",
+				"waiting/3": "{}",
+			})
+			later(t, c.age)
+			var args []string
+			file := filepath.Join(t.TempDir(), "archive")
+			if c.archive {
+				args = []string{"-archive", file}
+			}
+			if err := a.purge(args); err != nil {
+				t.Fatal(err)
+			}
+			eq(t, c.out, output(a))
+			eq(t, c.jobs, states(t, a))
+			if !c.archive {
+				return
+			}
+			data, _ := os.ReadFile(file)
+			var l []string
+			for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+				var e struct {
+					ID     string
+					Job    json.RawMessage
+					Result string
+				}
+				json.Unmarshal([]byte(line), &e)
+				l = append(l, e.ID+" "+string(e.Job)+" "+e.Result)
+			}
+			eq(t, "1 {\"crq\":\"c1\"} Error: x\n\n2 \"bad\" ", strings.Join(l, "\n"))
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	a := setupQueue(t, map[string]string{
+		"waiting/1":    "{}",
+		"waiting/2":    "{}",
+		"inprogress/3": "{}",
+		"finished/4":   `{"_netspoc_api":{"user":"u1"}}`,
+		"result/4":     "",
+		"finished/5":   `{"_netspoc_api":{"user":"u1"}}`,
+		"result/5":     "Error: a\nmore",
+		"finished/6":   `{"_netspoc_api":{"user":"u2"}}`,
+		"result/6":     "Error: a\n",
+		"finished/7":   `{"user":"u2"}`,
+	})
+	a.json = true
+	later(t, time.Hour)
+	if err := a.stats([]string{"-since", "10h"}); err != nil {
+		t.Fatal(err)
+	}
+	var s summary
+	json.Unmarshal([]byte(output(a)), &s)
+	s.Since = time.Time{}
+	want := summary{
+		Waiting:    2,
+		InProgress: 1,
+		Finished:   4,
+		Errors:     3,
+		PerHour:    0.4,
+		ErrorRate:  0.75,
+		OldestWait: "1h0m0s",
+		ByUser:     map[string]int{"u1": 2, "u2": 2},
+		ByError:    map[string]int{"Error: a": 2, "Missing result": 1},
+	}
+	if d := cmp.Diff(want, s); d != "" {
+		t.Error(d)
+	}
+
+	// Jobs finished before given time aren't counted.
+	a.out = new(strings.Builder)
+	if err := a.stats([]string{"-since", "30m"}); err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal([]byte(output(a)), &s)
+	eq(t, "0 0", fmt.Sprintf("%d %d", s.Finished, s.Errors))
+}
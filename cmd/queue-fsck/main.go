@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hknutzen/Netspoc-API/internal/queue"
+)
+
+// Check directories of job queue for inconsistencies, that may be
+// left after crash of api-server or backend. With option -repair,
+// inconsistencies are repaired. Is safe to run while api-server and
+// backend are running, because recently changed files are ignored.
+func main() {
+	dir := flag.String("dir", os.Getenv("HOME"),
+		"Directory with job queue")
+	repair := flag.Bool("repair", false, "Repair found problems")
+	dryRun := flag.Bool("n", false,
+		"Only show, what would be repaired with -repair")
+	age := flag.Duration("age", 10*time.Minute,
+		"Ignore files changed during this `duration`")
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	s := queue.NewDirStore(*dir)
+	problems, err := s.Check(*age)
+	if err != nil {
+		abort(err)
+	}
+	left := 0
+	for _, p := range problems {
+		fmt.Println(p.Text)
+		switch {
+		case p.Repair == "":
+			left++
+		case !*repair:
+			left++
+			fmt.Println("  Can repair:", p.Repair)
+		case *dryRun:
+			left++
+			fmt.Println("  Would", p.Repair)
+		default:
+			if err := p.Fix(); err != nil {
+				left++
+				fmt.Printf("  Failed to %s: %v\n", p.Repair, err)
+			} else {
+				fmt.Println("  Did", p.Repair)
+			}
+		}
+	}
+	if left != 0 {
+		os.Exit(1)
+	}
+}
+
+func abort(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
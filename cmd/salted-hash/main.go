@@ -1,18 +1,135 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"os"
 
+	"github.com/hknutzen/Netspoc-API/internal/pwhash"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
 )
 
-// Create salted hash from cleartext password read from stdin.
+// Create salted hash from cleartext password.
+// Password is read from terminal without echo and must be typed twice.
+// If stdin isn't a terminal, password is read from first line of stdin.
+// With option -token, generate random API token and print token
+// together with its hash.
 func main() {
-	pass, _ := io.ReadAll(os.Stdin)
-	pass, _, _ = bytes.Cut(pass, []byte("\n"))
-	hash, _ := bcrypt.GenerateFromPassword(pass, bcrypt.MinCost)
-	fmt.Println(string(hash))
+	token := flag.Bool("token", false, "Generate API token")
+	cost := flag.Int("cost", bcrypt.DefaultCost, "Cost of bcrypt hash")
+	useArgon := flag.Bool("argon2id", false, "Create argon2id hash")
+	memory := flag.Uint("memory", uint(pwhash.DefaultArgon2.Memory),
+		"Memory of argon2id in KiB")
+	iterations := flag.Uint("time", uint(pwhash.DefaultArgon2.Time),
+		"Iterations of argon2id")
+	threads := flag.Uint("threads", uint(pwhash.DefaultArgon2.Threads),
+		"Parallelism of argon2id")
+	verify := flag.String("verify", "", "Check password against `hash`")
+	user := flag.String("user", "",
+		"Print entry for `name` to be pasted into config")
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *token {
+		b := make([]byte, 32)
+		rand.Read(b)
+		tok := hex.EncodeToString(b)
+		sum := sha256.Sum256([]byte(tok))
+		fmt.Println("token:", tok)
+		fmt.Println("hash: ", hex.EncodeToString(sum[:]))
+		return
+	}
+	if *verify != "" {
+		pass, err := readPassword(false)
+		if err != nil {
+			abort(err)
+		}
+		ok, err := pwhash.Verify(*verify, pass)
+		if err != nil {
+			abort(err)
+		}
+		if !ok {
+			abort(errors.New("Password doesn't match"))
+		}
+		fmt.Println("Password matches")
+		return
+	}
+	if *cost < bcrypt.MinCost || *cost > bcrypt.MaxCost {
+		abort(fmt.Errorf("Cost must be between %d and %d",
+			bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	if *threads > 255 || *memory>>32 != 0 || *iterations>>32 != 0 {
+		abort(errors.New("Parameter of argon2id is too large"))
+	}
+	pass, err := readPassword(true)
+	if err != nil {
+		abort(err)
+	}
+	var hash string
+	if *useArgon {
+		hash, err = pwhash.Argon2id(pass, pwhash.Argon2Params{
+			Memory:  uint32(*memory),
+			Time:    uint32(*iterations),
+			Threads: uint8(*threads),
+		})
+	} else {
+		hash, err = pwhash.Bcrypt(pass, *cost)
+	}
+	if err != nil {
+		abort(err)
+	}
+	if *user == "" {
+		fmt.Println(hash)
+		return
+	}
+	name, _ := json.Marshal(*user)
+	entry, _ := json.Marshal(map[string]string{"hash": hash})
+	fmt.Printf("%s: %s\n", name, entry)
+}
+
+func readPassword(confirm bool) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadBytes('\n')
+		if err != nil && len(line) == 0 {
+			return nil, errors.New("Missing password on stdin")
+		}
+		return bytes.TrimRight(line, "\r\n"), nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	pass, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, errors.New("Empty password")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Retype password: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(pass, again) {
+			return nil, errors.New("Passwords don't match")
+		}
+	}
+	return pass, nil
+}
+
+func abort(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
 }
@@ -0,0 +1,167 @@
+// Package pwhash creates and verifies salted hashes of passwords.
+// Supported formats are bcrypt ("$2a$...", "$2b$...", "$2y$...")
+// and argon2id in PHC string format
+// "$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>".
+package pwhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Parameters of argon2id.
+// Memory is given in KiB.
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+// Defaults as recommended by RFC 9106.
+var DefaultArgon2 = Argon2Params{Memory: 64 * 1024, Time: 3, Threads: 4}
+
+const (
+	saltLen = 16
+	keyLen  = 32
+)
+
+var errFormat = errors.New("unknown format of hash")
+
+// Bcrypt creates bcrypt hash with given cost.
+func Bcrypt(pass []byte, cost int) (string, error) {
+	h, err := bcrypt.GenerateFromPassword(pass, cost)
+	return string(h), err
+}
+
+// Argon2id creates argon2id hash with random salt in PHC format.
+func Argon2id(pass []byte, p Argon2Params) (string, error) {
+	if p.Memory == 0 || p.Time == 0 || p.Threads == 0 {
+		return "", errors.New("parameters of argon2id must be positive")
+	}
+	salt := make([]byte, saltLen)
+	rand.Read(salt)
+	key := argon2.IDKey(pass, salt, p.Time, p.Memory, p.Threads, keyLen)
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads,
+		enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// Verify checks password against hash.
+// Returns error, if format of hash is invalid.
+func Verify(hash string, pass []byte) (bool, error) {
+	switch {
+	case isBcrypt(hash):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), pass)
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+	case strings.HasPrefix(hash, "$argon2id$"):
+		p, salt, key, err := parseArgon2(hash)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey(
+			pass, salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	}
+	return false, errFormat
+}
+
+// Check checks that hash has some supported format.
+func Check(hash string) error {
+	switch {
+	case isBcrypt(hash):
+		_, err := bcrypt.Cost([]byte(hash))
+		return err
+	case strings.HasPrefix(hash, "$argon2id$"):
+		_, _, _, err := parseArgon2(hash)
+		return err
+	}
+	return errFormat
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "$2y$")
+}
+
+func parseArgon2(hash string) (p Argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	if len(parts) != 6 {
+		err = errors.New("invalid argon2id hash")
+		return
+	}
+	var v int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &v); err != nil {
+		err = fmt.Errorf("invalid version of argon2id hash: %v", err)
+		return
+	}
+	if v != argon2.Version {
+		err = fmt.Errorf("unsupported version %d of argon2id hash", v)
+		return
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d",
+		&p.Memory, &p.Time, &p.Threads)
+	if err != nil || p.Memory == 0 || p.Time == 0 || p.Threads == 0 {
+		err = errors.New("invalid parameters of argon2id hash")
+		return
+	}
+	enc := base64.RawStdEncoding
+	salt, err = enc.DecodeString(parts[4])
+	if err != nil {
+		err = fmt.Errorf("invalid salt of argon2id hash: %v", err)
+		return
+	}
+	key, err = enc.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		err = errors.New("invalid key of argon2id hash")
+	}
+	return
+}
+
+// Policy describes the minimal strength of new hashes.
+// Algorithm is either "bcrypt" or "argon2id".
+type Policy struct {
+	Algorithm string
+	Cost      int
+	Argon2    Argon2Params
+}
+
+// Hash creates hash of password according to policy.
+func (p Policy) Hash(pass []byte) (string, error) {
+	if p.Algorithm == "argon2id" {
+		return Argon2id(pass, p.Argon2)
+	}
+	return Bcrypt(pass, p.Cost)
+}
+
+// Weak checks if hash uses other algorithm or weaker parameters
+// than required by policy.
+func (p Policy) Weak(hash string) bool {
+	switch p.Algorithm {
+	case "bcrypt":
+		if !isBcrypt(hash) {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < p.Cost
+	case "argon2id":
+		a, _, _, err := parseArgon2(hash)
+		return err != nil ||
+			a.Memory < p.Argon2.Memory ||
+			a.Time < p.Argon2.Time ||
+			a.Threads < p.Argon2.Threads
+	}
+	return false
+}
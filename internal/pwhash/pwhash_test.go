@@ -0,0 +1,77 @@
+package pwhash
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestVerify(t *testing.T) {
+	small := Argon2Params{Memory: 64, Time: 1, Threads: 1}
+	a, err := Argon2id([]byte("secret"), small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Bcrypt([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range []string{a, b} {
+		if err := Check(hash); err != nil {
+			t.Errorf("%s: %v", hash, err)
+		}
+		if ok, err := Verify(hash, []byte("secret")); !ok || err != nil {
+			t.Errorf("%s: password not verified: %v", hash, err)
+		}
+		if ok, err := Verify(hash, []byte("other")); ok || err != nil {
+			t.Errorf("%s: wrong password verified: %v", hash, err)
+		}
+	}
+	for _, hash := range []string{
+		"",
+		"secret",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA$***",
+		"$2a$04$short",
+	} {
+		if Check(hash) == nil {
+			t.Errorf("%q: invalid hash accepted", hash)
+		}
+		if ok, err := Verify(hash, []byte("secret")); ok || err == nil {
+			t.Errorf("%q: invalid hash not rejected", hash)
+		}
+	}
+}
+
+func TestWeak(t *testing.T) {
+	small := Argon2Params{Memory: 64, Time: 1, Threads: 1}
+	a, _ := Argon2id([]byte("secret"), small)
+	b4, _ := Bcrypt([]byte("secret"), 4)
+	b5, _ := Bcrypt([]byte("secret"), 5)
+	bcrypt5 := Policy{Algorithm: "bcrypt", Cost: 5}
+	argon := Policy{Algorithm: "argon2id", Argon2: small}
+	stronger := Policy{Algorithm: "argon2id",
+		Argon2: Argon2Params{Memory: 64, Time: 2, Threads: 1}}
+	for _, c := range []struct {
+		p    Policy
+		hash string
+		weak bool
+	}{
+		{bcrypt5, b4, true},
+		{bcrypt5, b5, false},
+		{bcrypt5, a, true},
+		{argon, a, false},
+		{argon, b5, true},
+		{stronger, a, true},
+	} {
+		if w := c.p.Weak(c.hash); w != c.weak {
+			t.Errorf("%+v: weak(%s) = %v", c.p, c.hash, w)
+		}
+	}
+	h, err := stronger.Hash([]byte("secret"))
+	if err != nil || stronger.Weak(h) {
+		t.Errorf("New hash %s is weak: %v", h, err)
+	}
+}
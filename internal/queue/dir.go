@@ -0,0 +1,361 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// DirStore keeps each job in a file named by ID of job.
+// Directory of file gives state of job.
+// Result of finished job is stored in directory result/.
+// ID of last added job is stored in file job-counter.
+// File job-counter.lock is locked while counter is changed.
+type DirStore struct {
+	Dir string
+	// Creates ID of new job. If not set, IDs are taken from job
+	// counter.
+	NewID func() string
+}
+
+const (
+	counterFile = "job-counter"
+	counterLock = "job-counter.lock"
+)
+
+var stateDirs = map[State]string{
+	Waiting:    "waiting",
+	InProgress: "inprogress",
+	Finished:   "finished",
+}
+
+// Directories, where jobs are kept during their lifetime.
+// ID of new job must not be used in any of these.
+var jobDirs = []string{"waiting", "inprogress", "finished", "result"}
+
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{Dir: dir}
+}
+
+func (s *DirStore) path(elem ...string) string {
+	return filepath.Join(append([]string{s.Dir}, elem...)...)
+}
+
+// Job is written to temporary file first and then is linked
+// exclusively into waiting/. Counter is advanced past IDs, that
+// are already in use.
+func (s *DirStore) Add(data []byte) (string, error) {
+	os.Mkdir(s.path("waiting"), 0755)
+	tmpName, err := s.writeTmp("new-job-", data)
+	if err != nil {
+		return "", fmt.Errorf("Writing job: %v", err)
+	}
+	defer os.Remove(tmpName)
+	if s.NewID != nil {
+		for {
+			id := s.NewID()
+			if s.exists(id) {
+				continue
+			}
+			err := os.Link(tmpName, s.path("waiting", id))
+			if errors.Is(err, os.ErrExist) {
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			return id, syncDir(s.path("waiting"))
+		}
+	}
+	var id string
+	err = s.withCounter(func(count int) (int, error) {
+		for {
+			count++
+			if !s.exists(strconv.Itoa(count)) {
+				break
+			}
+		}
+		id = strconv.Itoa(count)
+		// Fails if job has been created meanwhile by other process.
+		if err := os.Link(tmpName, s.path("waiting", id)); err != nil {
+			return 0, err
+		}
+		return count, syncDir(s.path("waiting"))
+	})
+	return id, err
+}
+
+// Write data to new file in directory tmp/ and return its name.
+func (s *DirStore) writeTmp(prefix string, data []byte) (string, error) {
+	os.Mkdir(s.path("tmp"), 0755)
+	fh, err := os.CreateTemp(s.path("tmp"), prefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = fh.Write(data)
+	if err == nil {
+		err = fh.Sync()
+	}
+	if err2 := fh.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(fh.Name())
+		return "", err
+	}
+	return fh.Name(), nil
+}
+
+func (s *DirStore) exists(id string) bool {
+	for _, dir := range jobDirs {
+		if _, err := os.Lstat(s.path(dir, id)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Call function f with value of locked job counter and store
+// value returned by f as new value of counter.
+func (s *DirStore) withCounter(f func(int) (int, error)) error {
+	fh, err := os.OpenFile(s.path(counterLock), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	// Lock counter for exclusive access.
+	err = syscall.Flock(int(fh.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return fmt.Errorf("Can't get lock: %v", err)
+	}
+	// Read job count; file is missing on first run.
+	count := 0
+	data, err := os.ReadFile(s.path(counterFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	fmt.Sscan(string(data), &count)
+	next, err := f(count)
+	if err != nil || next == count {
+		return err
+	}
+	if err := s.writeCounter(next); err != nil {
+		return fmt.Errorf("Writing %s: %v", counterFile, err)
+	}
+	return nil
+}
+
+// Counter is written to temporary file, that replaces old file.
+// Hence counter is never left empty or partially written after
+// crash.
+func (s *DirStore) writeCounter(count int) error {
+	tmp, err := s.writeTmp("job-counter-", []byte(strconv.Itoa(count)+"\n"))
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path(counterFile)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(s.path(counterFile)))
+}
+
+// Recover advances job counter to highest ID of existing jobs.
+// Counter may be behind after crash or restore from backup.
+// Returns old and new value of counter.
+func (s *DirStore) Recover() (int, int, error) {
+	var old, high int
+	err := s.withCounter(func(count int) (int, error) {
+		old, high = count, count
+		for _, dir := range append(jobDirs, "tmp") {
+			entries, _ := os.ReadDir(s.path(dir))
+			for _, e := range entries {
+				if id, err := strconv.Atoi(e.Name()); err == nil && id > high {
+					high = id
+				}
+			}
+		}
+		return high, nil
+	})
+	return old, high, err
+}
+
+func (s *DirStore) Get(id string) (*Job, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	// Job moves from waiting/ to inprogress/ to finished/.
+	// Check in this order to find job, that is moved meanwhile.
+	for _, state := range []State{Waiting, InProgress, Finished} {
+		if j, err := s.read(state, id); err == nil {
+			return j, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *DirStore) read(state State, id string) (*Job, error) {
+	fh, err := os.Open(s.path(stateDirs[state], id))
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	fi, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, fi.Size())
+	if _, err := fh.ReadAt(data, 0); err != nil && fi.Size() != 0 {
+		return nil, err
+	}
+	return &Job{ID: id, State: state, Data: data, Changed: changeTime(fi)}, nil
+}
+
+// Time of last change of file status.
+// This is changed, when file is moved to other directory.
+func changeTime(fi os.FileInfo) time.Time {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Ctim.Sec, st.Ctim.Nsec)
+	}
+	return fi.ModTime()
+}
+
+// ID must not be used to access other files.
+func validID(id string) bool {
+	return id != "" && id != "." && id != ".." && filepath.Base(id) == id
+}
+
+func (s *DirStore) Result(id string) ([]byte, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	return os.ReadFile(s.path("result", id))
+}
+
+func (s *DirStore) List(f Filter) ([]*Job, error) {
+	var result []*Job
+	for _, state := range []State{Waiting, InProgress, Finished} {
+		if f.State != "" && f.State != state {
+			continue
+		}
+		entries, err := os.ReadDir(s.path(stateDirs[state]))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		for _, e := range entries {
+			j, err := s.read(state, e.Name())
+			if errors.Is(err, os.ErrNotExist) {
+				// Has been moved meanwhile.
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if f.match(j) {
+				result = append(result, j)
+			}
+		}
+	}
+	slices.SortFunc(result, func(a, b *Job) int { return CompareID(a.ID, b.ID) })
+	return result, nil
+}
+
+// CompareID orders IDs by time of creation.
+// Sequential IDs are compared as numbers.
+func CompareID(a, b string) int {
+	x, err1 := strconv.Atoi(a)
+	y, err2 := strconv.Atoi(b)
+	if err1 == nil && err2 == nil {
+		return x - y
+	}
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (s *DirStore) Move(id string, from, to State, result []byte) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	src := s.path(stateDirs[from], id)
+	if _, err := os.Lstat(src); err != nil {
+		return ErrNotFound
+	}
+	if err := checkMove(from, to); err != nil {
+		return err
+	}
+	if to == Finished {
+		// Result must be available, when job is seen as finished.
+		os.Mkdir(s.path("result"), 0755)
+		tmp, err := s.writeTmp("result-", result)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, s.path("result", id)); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := syncDir(s.path("result")); err != nil {
+			return err
+		}
+	}
+	dst := s.path(stateDirs[to], id)
+	os.Mkdir(filepath.Dir(dst), 0755)
+	if err := os.Link(src, dst); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	if err := os.Remove(src); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(src))
+}
+
+func (s *DirStore) Delete(id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	found := false
+	for _, dir := range jobDirs {
+		if err := os.Remove(s.path(dir, id)); err == nil {
+			found = true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *DirStore) Close() error { return nil }
+
+// Make changed entries of directory persistent.
+func syncDir(dir string) error {
+	fh, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = fh.Sync()
+	if err2 := fh.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,195 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Problem is an inconsistency of DirStore found by Check.
+type Problem struct {
+	Text string
+	// Description of repair; is empty, if problem can't be repaired.
+	Repair string
+	fix    func() error
+}
+
+// Fix repairs problem. Condition of problem is checked again, since
+// directories may have been changed meanwhile by other process.
+func (p *Problem) Fix() error {
+	if p.fix == nil {
+		return fmt.Errorf("Can't repair")
+	}
+	return p.fix()
+}
+
+// Message stored as result of finished job, whose result is missing.
+const LostResult = "Error: Result of job has been lost;" +
+	" changes may have been applied\n"
+
+// Check finds inconsistencies in directories of DirStore.
+// Files changed during the last minAge are ignored, because they may
+// currently be processed by api-server or backend.
+func (s *DirStore) Check(minAge time.Duration) ([]*Problem, error) {
+	limit := time.Now().Add(-minAge)
+	// Map from ID to directories, where job has been found.
+	found := make(map[string][]string)
+	var problems []*Problem
+	add := func(p *Problem) { problems = append(problems, p) }
+	old := func(dir, name string) bool {
+		fi, err := os.Lstat(s.path(dir, name))
+		return err == nil && changeTime(fi).Before(limit)
+	}
+	high := 0
+	for _, dir := range append(jobDirs, "tmp") {
+		entries, err := os.ReadDir(s.path(dir))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if n, err := strconv.Atoi(name); err == nil && n > high {
+				high = n
+			}
+			if !old(dir, name) {
+				continue
+			}
+			if dir == "tmp" {
+				add(&Problem{
+					Text:   "Orphaned file tmp/" + name,
+					Repair: "remove tmp/" + name,
+					fix: func() error {
+						if !old(dir, name) {
+							return nil
+						}
+						return os.Remove(s.path(dir, name))
+					},
+				})
+				continue
+			}
+			found[name] = append(found[name], dir)
+		}
+	}
+	for _, id := range slices.SortedFunc(maps.Keys(found), CompareID) {
+		dirs := found[id]
+		has := func(d string) bool { return slices.Contains(dirs, d) }
+		// Remove copies of job in earlier states.
+		var keep string
+		switch {
+		case has("finished") && has("result"):
+			keep = "finished"
+		case has("inprogress"):
+			keep = "inprogress"
+		case has("finished"):
+			keep = "finished"
+		case has("waiting"):
+			keep = "waiting"
+		default:
+			add(&Problem{
+				Text:   "Orphaned file result/" + id,
+				Repair: "remove result/" + id,
+				fix: s.removeOrphan(
+					id, "result", "waiting", "inprogress", "finished"),
+			})
+			continue
+		}
+		for _, dir := range []string{"waiting", "inprogress", "finished"} {
+			if dir != keep && has(dir) {
+				add(&Problem{
+					Text:   fmt.Sprintf("Job %s in %s/ and %s/", id, dir, keep),
+					Repair: fmt.Sprintf("remove %s/%s", dir, id),
+					fix:    s.removeIf(id, dir, keep),
+				})
+			}
+		}
+		if keep == "finished" && !has("result") {
+			add(&Problem{
+				Text:   fmt.Sprintf("Missing result/%s of finished job", id),
+				Repair: fmt.Sprintf("write error message to result/%s", id),
+				fix: func() error {
+					if _, err := os.Lstat(s.path("finished", id)); err != nil {
+						return nil
+					}
+					tmp, err := s.writeTmp("result-", []byte(LostResult))
+					if err != nil {
+						return err
+					}
+					defer os.Remove(tmp)
+					// Fails, if result has been written meanwhile.
+					if err := os.Link(tmp, s.path("result", id)); err != nil {
+						if errors.Is(err, os.ErrExist) {
+							return nil
+						}
+						return err
+					}
+					return syncDir(s.path("result"))
+				},
+			})
+		}
+		if keep == "waiting" && has("result") {
+			add(&Problem{
+				Text:   fmt.Sprintf("Waiting job %s has result/%s", id, id),
+				Repair: "remove result/" + id,
+				fix:    s.removeOrphan(id, "result", "inprogress", "finished"),
+			})
+		}
+		data, err := os.ReadFile(s.path(keep, id))
+		if err == nil && !json.Valid(data) {
+			add(&Problem{Text: fmt.Sprintf("Job %s/%s has invalid JSON", keep, id)})
+		}
+	}
+	// Counter is only read here and is locked during repair.
+	count := 0
+	if data, err := os.ReadFile(s.path(counterFile)); err == nil {
+		fmt.Sscan(string(data), &count)
+	}
+	if count < high {
+		add(&Problem{
+			Text: fmt.Sprintf("%s %d is lower than highest ID %d",
+				counterFile, count, high),
+			Repair: fmt.Sprintf("advance %s to %d", counterFile, high),
+			fix: func() error {
+				_, _, err := s.Recover()
+				return err
+			},
+		})
+	}
+	return problems, nil
+}
+
+// Returns function, that removes file of job in directory dir,
+// if job is still available in directory keep.
+func (s *DirStore) removeIf(id, dir, keep string) func() error {
+	return func() error {
+		if _, err := os.Lstat(s.path(keep, id)); err != nil {
+			return nil
+		}
+		return s.remove(dir, id)
+	}
+}
+
+// Returns function, that removes file of job in directory dir,
+// if job still isn't available in any of directories others.
+func (s *DirStore) removeOrphan(id, dir string, others ...string) func() error {
+	return func() error {
+		for _, d := range others {
+			if _, err := os.Lstat(s.path(d, id)); err == nil {
+				return nil
+			}
+		}
+		return s.remove(dir, id)
+	}
+}
+
+func (s *DirStore) remove(dir, id string) error {
+	err := os.Remove(s.path(dir, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,306 @@
+package queue
+
+import (
+	"errors"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Index keeps state and attributes of all jobs of DirStore in memory.
+// Jobs are read once at start. Afterwards, changes of directories are
+// seen by inotify. Index is reconciled with directories periodically,
+// in case some event has been missed.
+type Index struct {
+	*DirStore
+	mu   sync.RWMutex
+	jobs map[string]*entry
+	// File descriptor of inotify.
+	file *os.File
+	wd   map[int32]State
+	done chan struct{}
+}
+
+type entry struct {
+	state   State
+	changed time.Time
+	info    Info
+	// File of job had invalid JSON. It may have been read, before it
+	// was written completely by other process. Hence it is read
+	// again, when job is requested.
+	partial bool
+	// Time, when entry was updated in index.
+	updated time.Time
+}
+
+// OpenIndex builds index of jobs in s and keeps it current.
+func OpenIndex(s *DirStore, interval time.Duration) (*Index, error) {
+	// Non blocking file descriptor is handled by runtime poller,
+	// hence Read is interrupted by Close.
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	x := &Index{
+		DirStore: s,
+		jobs:     make(map[string]*entry),
+		file:     os.NewFile(uintptr(fd), "inotify"),
+		wd:       make(map[int32]State),
+		done:     make(chan struct{}),
+	}
+	const mask = syscall.IN_CREATE | syscall.IN_MOVED_TO |
+		syscall.IN_DELETE | syscall.IN_CLOSE_WRITE
+	for state, dir := range stateDirs {
+		path := s.path(dir)
+		os.MkdirAll(path, 0755)
+		wd, err := syscall.InotifyAddWatch(fd, path, mask)
+		if err != nil {
+			x.file.Close()
+			return nil, err
+		}
+		x.wd[int32(wd)] = state
+	}
+	// Watch is started before reading directories,
+	// hence no change is missed.
+	if err := x.Reconcile(); err != nil {
+		x.file.Close()
+		return nil, err
+	}
+	go x.watch()
+	go x.reconcileEvery(interval)
+	return x, nil
+}
+
+func (x *Index) Close() error {
+	close(x.done)
+	return x.file.Close()
+}
+
+func (x *Index) reconcileEvery(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-x.done:
+			return
+		case <-t.C:
+			if err := x.Reconcile(); err != nil {
+				log.Printf("Can't reconcile index of jobs: %v", err)
+			}
+		}
+	}
+}
+
+// Read events of inotify until file descriptor is closed.
+func (x *Index) watch() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := x.file.Read(buf)
+		if err != nil {
+			select {
+			case <-x.done:
+			default:
+				log.Printf("Stopped watching jobs: %v", err)
+			}
+			return
+		}
+		for i := 0; i+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[i]))
+			raw := buf[i+syscall.SizeofInotifyEvent : i+syscall.SizeofInotifyEvent+int(ev.Len)]
+			i += syscall.SizeofInotifyEvent + int(ev.Len)
+			name := string(raw[:clen(raw)])
+			x.handle(ev.Mask, x.wd[ev.Wd], name)
+		}
+	}
+}
+
+// Length of NUL terminated string.
+func clen(b []byte) int {
+	for i, c := range b {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(b)
+}
+
+func (x *Index) handle(mask uint32, state State, id string) {
+	switch {
+	case mask&syscall.IN_Q_OVERFLOW != 0:
+		if err := x.Reconcile(); err != nil {
+			log.Printf("Can't reconcile index of jobs: %v", err)
+		}
+	case state == "" || !validID(id):
+	case mask&syscall.IN_CLOSE_WRITE != 0:
+		// File has been created and written by other process.
+		// It may have been incomplete at IN_CREATE.
+		if j, err := x.read(state, id); err == nil {
+			x.set(j)
+		}
+	case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		x.found(id, state)
+	case mask&syscall.IN_DELETE != 0:
+		// Job, that is moved away, is kept until it is seen in
+		// other directory. Otherwise status of job would be unknown
+		// for a short time.
+		x.mu.Lock()
+		// Ignore, if job has already been seen in next state.
+		if e := x.jobs[id]; e != nil && e.state == state {
+			delete(x.jobs, id)
+		}
+		x.mu.Unlock()
+	}
+}
+
+// Job has been seen in directory of state.
+func (x *Index) found(id string, state State) {
+	x.mu.RLock()
+	e := x.jobs[id]
+	x.mu.RUnlock()
+	if e != nil {
+		x.mu.Lock()
+		e.state = state
+		e.changed = time.Now()
+		e.updated = e.changed
+		x.mu.Unlock()
+		return
+	}
+	j, err := x.read(state, id)
+	if err != nil {
+		// Has been moved meanwhile; is found by other event.
+		return
+	}
+	x.set(j)
+}
+
+// Add or update job in index.
+func (x *Index) set(j *Job) {
+	e := newEntry(j, time.Now())
+	x.mu.Lock()
+	x.jobs[j.ID] = e
+	x.mu.Unlock()
+}
+
+// Job with invalid JSON is indexed without attributes.
+func newEntry(j *Job, updated time.Time) *entry {
+	info, err := j.Info()
+	return &entry{state: j.State, changed: j.Changed, info: info,
+		partial: err != nil, updated: updated}
+}
+
+// Reconcile reads directories again and updates index.
+// Only files of new jobs are read.
+func (x *Index) Reconcile() error {
+	start := time.Now()
+	seen := make(map[string]State)
+	for state, dir := range stateDirs {
+		entries, err := os.ReadDir(x.path(dir))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for _, e := range entries {
+			seen[e.Name()] = state
+		}
+	}
+	var jobs []*Job
+	x.mu.RLock()
+	for id, state := range seen {
+		if e := x.jobs[id]; e == nil || e.state != state {
+			if j, err := x.read(state, id); err == nil {
+				jobs = append(jobs, j)
+			}
+		}
+	}
+	x.mu.RUnlock()
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, j := range jobs {
+		// Don't overwrite change, that was seen while reading.
+		if e := x.jobs[j.ID]; e != nil && e.updated.After(start) {
+			continue
+		}
+		x.jobs[j.ID] = newEntry(j, start)
+	}
+	for id, e := range x.jobs {
+		if _, ok := seen[id]; !ok && !e.updated.After(start) {
+			delete(x.jobs, id)
+		}
+	}
+	return nil
+}
+
+func (x *Index) Add(data []byte) (string, error) {
+	id, err := x.DirStore.Add(data)
+	if err == nil {
+		// Job must be known at once, before event is seen.
+		x.set(&Job{ID: id, State: Waiting, Data: data, Changed: time.Now()})
+	}
+	return id, err
+}
+
+func (x *Index) Get(id string) (*Job, error) {
+	x.mu.RLock()
+	var j *Job
+	partial := false
+	if e := x.jobs[id]; e != nil {
+		j, partial = e.job(id), e.partial
+	}
+	x.mu.RUnlock()
+	if j == nil {
+		return nil, ErrNotFound
+	}
+	if partial {
+		if r, err := x.read(j.State, id); err == nil {
+			x.set(r)
+			return r, nil
+		}
+	}
+	return j, nil
+}
+
+func (e *entry) job(id string) *Job {
+	info := e.info
+	return &Job{ID: id, State: e.state, Changed: e.changed, info: &info}
+}
+
+func (x *Index) List(f Filter) ([]*Job, error) {
+	x.mu.RLock()
+	var result []*Job
+	for id, e := range x.jobs {
+		if j := e.job(id); f.match(j) {
+			result = append(result, j)
+		}
+	}
+	x.mu.RUnlock()
+	slices.SortFunc(result, func(a, b *Job) int { return CompareID(a.ID, b.ID) })
+	return result, nil
+}
+
+func (x *Index) Move(id string, from, to State, result []byte) error {
+	if err := x.DirStore.Move(id, from, to, result); err != nil {
+		return err
+	}
+	x.mu.Lock()
+	if e := x.jobs[id]; e != nil {
+		e.state = to
+		e.changed = time.Now()
+		e.updated = e.changed
+	}
+	x.mu.Unlock()
+	return nil
+}
+
+func (x *Index) Delete(id string) error {
+	err := x.DirStore.Delete(id)
+	x.mu.Lock()
+	delete(x.jobs, id)
+	x.mu.Unlock()
+	return err
+}
@@ -0,0 +1,128 @@
+// Package queue stores jobs of Netspoc-API.
+//
+// A job is added in state WAITING. The backend moves it to state
+// INPROGRESS, while it is processed, and finally to state FINISHED
+// together with its result. An empty result means success.
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type State string
+
+const (
+	Waiting    State = "WAITING"
+	InProgress State = "INPROGRESS"
+	Finished   State = "FINISHED"
+)
+
+// ErrNotFound is returned, if job with given ID isn't known.
+var ErrNotFound = errors.New("job not found")
+
+// Store is implemented by DirStore, which uses the directory layout
+// known by backend/process-queue, and by SQLStore.
+type Store interface {
+	// Add stores new job in state WAITING and returns its ID.
+	Add(data []byte) (string, error)
+	// Get returns job with given ID or ErrNotFound.
+	Get(id string) (*Job, error)
+	// Result returns result of finished job.
+	Result(id string) ([]byte, error)
+	// List returns jobs matching filter, oldest first.
+	List(f Filter) ([]*Job, error)
+	// Move changes state of job, if it currently has state from.
+	// Result is stored, when job is moved to FINISHED.
+	Move(id string, from, to State, result []byte) error
+	// Delete removes job together with its result.
+	Delete(id string) error
+	Close() error
+}
+
+// Empty attributes match all jobs.
+type Filter struct {
+	State State
+	User  string
+	CRQ   string
+}
+
+type Job struct {
+	ID    string
+	State State
+	// Job as stored by api-server.
+	// Is nil, if job is taken from Index.
+	Data []byte
+	// Time of last change of state.
+	Changed time.Time
+	// Parsed attributes, if known already.
+	info *Info
+}
+
+// Metadata added to job by api-server, stored with key MetaKey.
+type Meta struct {
+	User      string `json:"user"`
+	Submitted string `json:"submitted"`
+	Source    string `json:"source"`
+	RequestID string `json:"request_id"`
+}
+
+const MetaKey = "_netspoc_api"
+
+// Attributes of job, that are used for access control and search.
+type Info struct {
+	User   string
+	CRQ    string
+	Method string
+	Meta   Meta
+}
+
+// Info parses attributes of job.
+func (j *Job) Info() (Info, error) {
+	if j.info != nil {
+		return *j.info, nil
+	}
+	var v struct {
+		User   string
+		CRQ    string
+		Method string
+		Meta   *Meta `json:"_netspoc_api"`
+	}
+	if err := json.Unmarshal(j.Data, &v); err != nil {
+		return Info{}, err
+	}
+	info := Info{User: v.User, CRQ: v.CRQ, Method: v.Method}
+	// Jobs of older versions have user at top level.
+	if v.Meta != nil {
+		info.Meta = *v.Meta
+		info.User = v.Meta.User
+	}
+	return info, nil
+}
+
+func (f Filter) match(j *Job) bool {
+	if f.State != "" && f.State != j.State {
+		return false
+	}
+	if f.User == "" && f.CRQ == "" {
+		return true
+	}
+	info, err := j.Info()
+	return err == nil &&
+		(f.User == "" || f.User == info.User) &&
+		(f.CRQ == "" || f.CRQ == info.CRQ)
+}
+
+// Job is processed only once, hence it can't be moved back from
+// FINISHED.
+func checkMove(from, to State) error {
+	switch {
+	case from == Waiting && to == InProgress,
+		from == InProgress && to == Waiting,
+		from == InProgress && to == Finished:
+		return nil
+	}
+	return fmt.Errorf("Can't move job from %s to %s", from, to)
+}
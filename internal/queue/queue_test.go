@@ -0,0 +1,327 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStores(t *testing.T) {
+	t.Run("dir", func(t *testing.T) {
+		testStore(t, NewDirStore(t.TempDir()))
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		s, err := OpenSQLStore(filepath.Join(t.TempDir(), "jobs.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		testStore(t, s)
+	})
+}
+
+func testStore(t *testing.T, s Store) {
+	var ids []string
+	for _, data := range []string{
+		`{"crq":"c1","_netspoc_api":{"user":"u1"}}`,
+		`{"crq":"c2","_netspoc_api":{"user":"u2"}}`,
+		`{"crq":"c2","user":"u1"}`,
+	} {
+		id, err := s.Add([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if ids[0] != "1" || ids[2] != "3" {
+		t.Errorf("Unexpected IDs: %v", ids)
+	}
+	list := func(f Filter) string {
+		t.Helper()
+		jobs, err := s.List(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r string
+		for _, j := range jobs {
+			r += j.ID + ":" + string(j.State) + " "
+		}
+		return r
+	}
+	eq := func(expected, got string) {
+		t.Helper()
+		if expected != got {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	}
+	eq("1:WAITING 2:WAITING 3:WAITING ", list(Filter{}))
+	eq("1:WAITING 3:WAITING ", list(Filter{User: "u1"}))
+	eq("2:WAITING ", list(Filter{User: "u2", CRQ: "c2"}))
+
+	if err := s.Move("1", Waiting, InProgress, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Move("1", Waiting, InProgress, nil); err != ErrNotFound {
+		t.Errorf("Moved job twice: %v", err)
+	}
+	if err := s.Move("1", InProgress, Finished, []byte("failed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Move("1", Finished, Waiting, nil); err == nil {
+		t.Errorf("Moved finished job")
+	}
+	if err := s.Move("2", Waiting, InProgress, nil); err != nil {
+		t.Fatal(err)
+	}
+	eq("1:FINISHED ", list(Filter{State: Finished}))
+	eq("2:INPROGRESS ", list(Filter{State: InProgress}))
+
+	j, err := s.Get("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	eq("FINISHED", string(j.State))
+	info, err := j.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	eq("u1 c1", info.User+" "+info.CRQ)
+	result, err := s.Result("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	eq("failed", string(result))
+
+	if _, err := s.Get("4"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := s.Get("../job-counter"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := s.Delete("1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Result("1"); err == nil {
+		t.Errorf("Result of deleted job found")
+	}
+	if err := s.Delete("1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	eq("2:INPROGRESS 3:WAITING ", list(Filter{}))
+}
+
+func TestCounter(t *testing.T) {
+	s := NewDirStore(t.TempDir())
+	read := func() string {
+		t.Helper()
+		data, _ := os.ReadFile(filepath.Join(s.Dir, counterFile))
+		return string(data)
+	}
+	if _, err := s.Add([]byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "1\n" {
+		t.Errorf("Unexpected counter %q", got)
+	}
+	// Counter is replaced by renamed file, lock is kept in other file.
+	if _, err := os.Stat(filepath.Join(s.Dir, counterLock)); err != nil {
+		t.Error(err)
+	}
+	os.WriteFile(filepath.Join(s.Dir, "waiting", "7"), []byte("{}"), 0644)
+	if old, high, err := s.Recover(); err != nil || old != 1 || high != 7 {
+		t.Errorf("Unexpected result of Recover: %d %d %v", old, high, err)
+	}
+	if got := read(); got != "7\n" {
+		t.Errorf("Unexpected counter %q", got)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(s.Dir, "tmp")); len(entries) != 0 {
+		t.Errorf("Temporary files left: %v", entries)
+	}
+}
+
+func TestULID(t *testing.T) {
+	now := time.Now()
+	a := ulidAt(now)
+	b := ulidAt(now)
+	c := ulidAt(now.Add(-time.Second))
+	d := ulidAt(now.Add(time.Millisecond))
+	if len(a) != 26 || strings.Trim(a, crockford) != "" {
+		t.Errorf("Invalid ULID %q", a)
+	}
+	if !(a < b && b < c && c < d) {
+		t.Errorf("ULIDs not monotonic: %s %s %s %s", a, b, c, d)
+	}
+	if a[:10] == d[:10] {
+		t.Errorf("Time not encoded: %s %s", a, d)
+	}
+
+	s := NewDirStore(t.TempDir())
+	s.NewID = NewULID
+	var ids []string
+	for range 3 {
+		id, err := s.Add([]byte(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	jobs, _ := s.List(Filter{})
+	if len(jobs) != 3 {
+		t.Fatalf("Expected 3 jobs, got %d", len(jobs))
+	}
+	for i, j := range jobs {
+		if j.ID != ids[i] {
+			t.Errorf("Jobs not sorted by time: %s != %s", j.ID, ids[i])
+		}
+	}
+	if _, err := os.Stat(filepath.Join(s.Dir, counterFile)); err == nil {
+		t.Errorf("Unexpected %s", counterFile)
+	}
+	if CompareID("9", ids[0]) >= 0 {
+		t.Errorf("Sequential ID must sort before ULID")
+	}
+}
+
+func TestIndex(t *testing.T) {
+	s := NewDirStore(t.TempDir())
+	id1, _ := s.Add([]byte(`{"crq":"c1","_netspoc_api":{"user":"u1"}}`))
+	x, err := OpenIndex(s, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	id2, _ := x.Add([]byte(`{"crq":"c2","_netspoc_api":{"user":"u2"}}`))
+	// Wait until index has seen given state of job.
+	wait := func(id string, state State) {
+		t.Helper()
+		for range 100 {
+			j, err := x.Get(id)
+			if state == "" && err == ErrNotFound ||
+				err == nil && j.State == state {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Job %s hasn't become %q", id, state)
+	}
+	// Wait until index has seen attributes of job.
+	waitUser := func(id, user string) {
+		t.Helper()
+		for range 100 {
+			if j, err := x.Get(id); err == nil {
+				if info, _ := j.Info(); info.User == user {
+					return
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Job %s hasn't got user %q", id, user)
+	}
+	wait(id1, Waiting)
+	wait(id2, Waiting)
+	j, _ := x.Get(id2)
+	if info, _ := j.Info(); info.User != "u2" || info.CRQ != "c2" {
+		t.Errorf("Unexpected info %v", info)
+	}
+
+	// Changes from other process.
+	os.Rename(filepath.Join(s.Dir, "waiting", id1),
+		filepath.Join(s.Dir, "inprogress", id1))
+	wait(id1, InProgress)
+	os.WriteFile(filepath.Join(s.Dir, "finished", "7"),
+		[]byte(`{"user":"u1"}`), 0644)
+	wait("7", Finished)
+	waitUser("7", "u1")
+	jobs, _ := x.List(Filter{User: "u1"})
+	if len(jobs) != 2 || jobs[0].ID != id1 || jobs[1].ID != "7" {
+		t.Errorf("Unexpected jobs %v", jobs)
+	}
+	os.Remove(filepath.Join(s.Dir, "finished", "7"))
+	wait("7", "")
+
+	// Job, that is seen while being written by other process.
+	fh, _ := os.Create(filepath.Join(s.Dir, "waiting", "9"))
+	defer fh.Close()
+	fh.WriteString(`{"user":`)
+	wait("9", Waiting)
+	fh.WriteString(`"u1"}`)
+	j, _ = x.Get("9")
+	if info, _ := j.Info(); info.User != "u1" {
+		t.Errorf("Unexpected info of partial job %v", info)
+	}
+
+	// Changes, that haven't been seen.
+	x.mu.Lock()
+	x.jobs["8"] = &entry{state: Waiting}
+	delete(x.jobs, id2)
+	x.mu.Unlock()
+	if err := x.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	wait("8", "")
+	wait(id2, Waiting)
+}
+
+func TestCheck(t *testing.T) {
+	s := NewDirStore(t.TempDir())
+	for _, f := range []string{
+		"waiting/1", "inprogress/1",
+		"inprogress/2", "finished/2", "result/2",
+		"finished/3",
+		"result/4",
+		"tmp/new-job-123",
+		"finished/5", "result/5",
+		"job-counter",
+	} {
+		path := filepath.Join(s.Dir, f)
+		os.Mkdir(filepath.Dir(path), 0755)
+		data := "{}"
+		switch f {
+		case "finished/5":
+			data = "{"
+		case "job-counter":
+			data = "2\n"
+		}
+		os.WriteFile(path, []byte(data), 0644)
+	}
+	check := func() string {
+		t.Helper()
+		l, err := s.Check(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r string
+		for _, p := range l {
+			r += p.Text + " => " + p.Repair + "\n"
+			if p.Repair != "" {
+				if err := p.Fix(); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+		return r
+	}
+	eq := func(expected, got string) {
+		t.Helper()
+		if expected != got {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+		}
+	}
+	eq(`Orphaned file tmp/new-job-123 => remove tmp/new-job-123
+Job 1 in waiting/ and inprogress/ => remove waiting/1
+Job 2 in inprogress/ and finished/ => remove inprogress/2
+Missing result/3 of finished job => write error message to result/3
+Orphaned file result/4 => remove result/4
+Job finished/5 has invalid JSON => 
+job-counter 2 is lower than highest ID 5 => advance job-counter to 5
+`, check())
+	eq("Job finished/5 has invalid JSON => \n", check())
+	data, _ := os.ReadFile(filepath.Join(s.Dir, "result", "3"))
+	eq(LostResult, string(data))
+	if l, _ := s.Check(time.Hour); len(l) != 0 {
+		t.Errorf("New files must be ignored")
+	}
+}
@@ -0,0 +1,197 @@
+package queue
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLStore keeps jobs in embedded SQLite database.
+// Changes of state are done in a transaction.
+type SQLStore struct {
+	db *sql.DB
+	// Creates ID of new job. If not set, sequential IDs are used.
+	NewID func() string
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS job (
+ seq     INTEGER PRIMARY KEY AUTOINCREMENT,
+ id      TEXT UNIQUE,
+ state   TEXT NOT NULL,
+ user    TEXT NOT NULL,
+ crq     TEXT NOT NULL,
+ data    BLOB NOT NULL,
+ result  BLOB,
+ changed INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS job_state ON job(state);
+CREATE INDEX IF NOT EXISTS job_user ON job(user);
+CREATE INDEX IF NOT EXISTS job_crq ON job(crq);
+`
+
+// OpenSQLStore opens or creates database in given file.
+func OpenSQLStore(file string) (*SQLStore, error) {
+	db, err := sql.Open("sqlite", file+
+		"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// Serialize transactions of this process.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLStore{db: db}, nil
+}
+
+func (s *SQLStore) Add(data []byte) (string, error) {
+	j := &Job{Data: data}
+	// Job with invalid JSON is stored without user and crq.
+	info, _ := j.Info()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	var id *string
+	if s.NewID != nil {
+		v := s.NewID()
+		id = &v
+	}
+	res, err := tx.Exec(
+		`INSERT INTO job(id, state, user, crq, data, changed)
+ VALUES(?, ?, ?, ?, ?, ?)`,
+		id, Waiting, info.User, info.CRQ, data, time.Now().UnixNano())
+	if err != nil {
+		return "", err
+	}
+	if id == nil {
+		seq, err := res.LastInsertId()
+		if err != nil {
+			return "", err
+		}
+		v := strconv.FormatInt(seq, 10)
+		_, err = tx.Exec(`UPDATE job SET id = ? WHERE seq = ?`, v, seq)
+		if err != nil {
+			return "", err
+		}
+		id = &v
+	}
+	return *id, tx.Commit()
+}
+
+const jobColumns = `id, state, data, changed`
+
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	var j Job
+	var changed int64
+	if err := row.Scan(&j.ID, &j.State, &j.Data, &changed); err != nil {
+		return nil, err
+	}
+	j.Changed = time.Unix(0, changed)
+	return &j, nil
+}
+
+func (s *SQLStore) Get(id string) (*Job, error) {
+	j, err := scanJob(s.db.QueryRow(
+		`SELECT `+jobColumns+` FROM job WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return j, err
+}
+
+func (s *SQLStore) Result(id string) ([]byte, error) {
+	var result []byte
+	err := s.db.QueryRow(
+		`SELECT result FROM job WHERE id = ? AND state = ?`,
+		id, Finished).Scan(&result)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return result, err
+}
+
+func (s *SQLStore) List(f Filter) ([]*Job, error) {
+	var where []string
+	var args []any
+	add := func(col, val string) {
+		if val != "" {
+			where = append(where, col+" = ?")
+			args = append(args, val)
+		}
+	}
+	add("state", string(f.State))
+	add("user", f.User)
+	add("crq", f.CRQ)
+	query := `SELECT ` + jobColumns + ` FROM job`
+	if where != nil {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	rows, err := s.db.Query(query+` ORDER BY seq`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []*Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, j)
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLStore) Move(id string, from, to State, result []byte) error {
+	if err := checkMove(from, to); err != nil {
+		return err
+	}
+	if to != Finished {
+		result = nil
+	} else if result == nil {
+		// Empty result means success and must be distinct from NULL.
+		result = []byte{}
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(
+		`UPDATE job SET state = ?, result = ?, changed = ?
+ WHERE id = ? AND state = ?`,
+		to, result, time.Now().UnixNano(), id, from)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) Delete(id string) error {
+	res, err := s.db.Exec(`DELETE FROM job WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// Alphabet of Crockford's base32.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var ulidState struct {
+	sync.Mutex
+	ms   uint64
+	rand [10]byte
+}
+
+// NewULID returns unguessable ID of 26 characters, that sorts by
+// time of creation. First 48 bits are time in milliseconds, the other
+// 80 bits are random. IDs created in the same millisecond are made
+// monotonic by incrementing the random part.
+func NewULID() string {
+	return ulidAt(time.Now())
+}
+
+func ulidAt(t time.Time) string {
+	s := &ulidState
+	s.Lock()
+	defer s.Unlock()
+	ms := uint64(t.UnixMilli())
+	if ms > s.ms || !increment(s.rand[:]) {
+		if ms > s.ms {
+			s.ms = ms
+		}
+		rand.Read(s.rand[:])
+		// Leave room for increments.
+		s.rand[0] &= 0x7f
+	}
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], s.ms<<16)
+	copy(b[6:], s.rand[:])
+	return encode(b)
+}
+
+// Increment big endian number, return false on overflow.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Encode 128 bits as 26 characters of base32, most significant first.
+func encode(b [16]byte) string {
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}